@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"testing"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/cfpb/rhobot/internal/database"
 	"github.com/cfpb/rhobot/internal/healthcheck"
 	"github.com/cfpb/rhobot/internal/report"
+	"github.com/urfave/cli"
 )
 
 var conf *config.Config
@@ -177,3 +179,66 @@ func TestCLI(t *testing.T) {
 	os.Args = []string{"rhobot", "pipeline"}
 	main()
 }
+
+func TestSplitTags(t *testing.T) {
+	tags := splitTags(" pii, post-load,,")
+	if len(tags) != 2 || tags[0] != "pii" || tags[1] != "post-load" {
+		t.Errorf("split tags into %q", tags)
+	}
+	if tags := splitTags(" , "); len(tags) != 0 {
+		t.Errorf("split blank tags into %q", tags)
+	}
+}
+
+func TestReadTrailingFlags(t *testing.T) {
+	flags := []cli.Flag{cli.StringFlag{Name: "dburi"}, cli.BoolFlag{Name: "tap"}, cli.StringSliceFlag{Name: "var"}}
+	context := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("healthchecks", flag.ContinueOnError)
+		for _, f := range flags {
+			f.Apply(set)
+		}
+		set.Parse(args)
+		return cli.NewContext(nil, set, nil)
+	}
+
+	c := context("checks.yml", "--dburi", "sqlite:///x.db", "--tap", "--var=a=1", "--var", "b=2")
+	args, err := readTrailingFlags(c, flags)
+	if err != nil || len(args) != 1 || args[0] != "checks.yml" {
+		t.Fatalf("read arguments %q: %v", args, err)
+	}
+	if c.String("dburi") != "sqlite:///x.db" || !c.Bool("tap") || len(c.StringSlice("var")) != 2 {
+		t.Errorf("trailing flags were not set: %v %v %v", c.String("dburi"), c.Bool("tap"), c.StringSlice("var"))
+	}
+
+	if _, err := readTrailingFlags(context("checks.yml", "--bogus"), flags); err == nil {
+		t.Error("an unknown flag was accepted")
+	}
+	if _, err := readTrailingFlags(context("checks.yml", "--dburi"), flags); err == nil {
+		t.Error("a flag without its value was accepted")
+	}
+}
+
+func TestLintExitCodes(t *testing.T) {
+	lint := func(args ...string) int {
+		set := flag.NewFlagSet("lint", flag.ContinueOnError)
+		cli.StringFlag{Name: "dburi", Value: "sqlite://" + t.TempDir() + "/lint.db"}.Apply(set)
+		cli.StringSliceFlag{Name: "var"}.Apply(set)
+		cli.StringFlag{Name: "format", Value: "text"}.Apply(set)
+		set.Parse(args)
+		err := lintHealthchecks(cli.NewContext(nil, set, nil), config.NewConfig())
+		if exitErr, ok := err.(*cli.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		return healthcheck.ExitPass
+	}
+
+	if code := lint("../../internal/healthcheck/healthchecksLint.yml"); code != healthcheck.ExitError {
+		t.Errorf("lint problems exited with %d", code)
+	}
+	if code := lint(); code != healthcheck.ExitConfig {
+		t.Errorf("lint without files exited with %d", code)
+	}
+	if code := lint("--format", "xml", "../../internal/healthcheck/healthchecksSQLite.yml"); code != healthcheck.ExitConfig {
+		t.Errorf("lint with an unknown format exited with %d", code)
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -12,7 +19,10 @@ import (
 	"github.com/cfpb/rhobot/internal/database"
 	"github.com/cfpb/rhobot/internal/gocd"
 	"github.com/cfpb/rhobot/internal/healthcheck"
+	"github.com/cfpb/rhobot/internal/jsonschema"
 	"github.com/cfpb/rhobot/internal/report"
+	"github.com/cfpb/rhobot/internal/schedule"
+	"github.com/cfpb/rhobot/internal/server"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/urfave/cli"
 )
@@ -33,14 +43,95 @@ func updateGOCDHost(c *cli.Context, config *config.Config) (gocdServer *gocd.Ser
 	return
 }
 
-func healthcheckRunner(config *config.Config, healthcheckPath string, reportPath string, templatePath string, emailListPath string, hcSchema string, hcTable string) (err error) {
-	healthChecks, err := healthcheck.ReadHealthCheckYAMLFromFile(healthcheckPath)
+// healthcheckOptions holds the cli arguments for a healthcheck run
+type healthcheckOptions struct {
+	HealthcheckPath string
+	ReportPath      string
+	JUnitPath       string
+	TAP             bool
+	PrometheusDir   string
+	TemplatePath    string
+	EmailListPath   string
+	Schema          string
+	Table           string
+	Concurrency     int
+	Timeout         time.Duration
+	Vars            map[string]string
+	Tags            []string
+	SkipTags        []string
+	TargetsPath     string
+	ParallelTargets bool
+	FailOn          string
+}
+
+// configError is the exit error of a healthcheck run that could not start
+func configError(format string, args ...interface{}) *cli.ExitError {
+	return cli.NewExitError(fmt.Sprintf(format, args...), healthcheck.ExitConfig)
+}
+
+// healthcheckRunner runs and reports the healthchecks. The error it returns
+// carries the exit code: ExitConfig when the run could not be set up,
+// otherwise the code of the worst failure at or above options.FailOn.
+func healthcheckRunner(config *config.Config, options healthcheckOptions) error {
+	if options.FailOn == "" {
+		options.FailOn = "error"
+	}
+	threshold, err := healthcheck.ParseFailOn(options.FailOn)
+	if err != nil {
+		return configError("%v", err)
+	}
+
+	healthChecks, err := healthcheck.ReadHealthChecksFromPath(options.HealthcheckPath, options.Vars)
+	if err != nil {
+		return configError("Failed to read healthchecks: %v", err)
+	}
+	if len(options.Tags) > 0 || len(options.SkipTags) > 0 {
+		healthChecks.FilterTags(options.Tags, options.SkipTags)
+		log.Infof("Selected %v healthchecks by tag", len(healthChecks.Tests))
+	}
+	if options.Concurrency > 0 {
+		healthChecks.Concurrency = options.Concurrency
+	}
+	if options.Timeout > 0 {
+		healthChecks.OverrideTimeout(options.Timeout)
+	}
+	targets := healthChecks.Targets
+	if options.TargetsPath != "" {
+		targets, err = healthcheck.ReadTargetsFromFile(options.TargetsPath, options.Vars)
+		if err != nil {
+			return configError("Failed to read targets: %v", err)
+		}
+	}
+
+	cxn, err := database.Open(config.DBURI())
 	if err != nil {
-		log.Fatal("Failed to read healthchecks: ", err)
+		return configError("Failed to open database: %v", err)
+	}
+	defer cxn.Close()
+	if len(targets) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = cxn.PingContext(ctx)
+		cancel()
+		if err != nil {
+			return configError("Failed to connect to database: %v", err)
+		}
+	}
+	if options.Schema != "" && options.Table != "" {
+		healthChecks.History = healthcheck.ResultsTable{Cxn: cxn, Schema: options.Schema, Table: options.Table}
 	}
-	cxn := database.GetPGConnection(config.DBURI())
 
-	results, HCerrs := healthChecks.PreformHealthChecks(cxn)
+	started := time.Now()
+	var runs []healthcheck.TargetResult
+	if len(targets) > 0 {
+		connect := func(target healthcheck.Target) (*sql.DB, error) {
+			return database.Open(target.DBURI)
+		}
+		runs = healthChecks.PreformHealthChecksOnTargets(targets, connect, healthChecks.ParallelTargets || options.ParallelTargets)
+	} else {
+		results, HCerrs := healthChecks.PreformHealthChecks(cxn)
+		runs = []healthcheck.TargetResult{{Results: results, Errors: HCerrs}}
+	}
+	results, HCerrs := healthcheck.CombineTargetResults(runs)
 	numErrors, numWarnings, fatal := healthcheck.EvaluateHCErrors(HCerrs)
 
 	var elements []report.Element
@@ -55,17 +146,29 @@ func healthcheckRunner(config *config.Config, healthcheckPath string, reportPath
 		"footer":    healthcheck.FooterHealthcheck,
 		"timestamp": time.Now().Format(time.ANSIC),
 		"status":    healthcheck.StatusHealthchecks(numErrors, numWarnings, fatal),
-		"schema":    hcSchema,
-		"table":     hcTable,
+		"schema":    options.Schema,
+		"table":     options.Table,
+		"read_only": healthChecks.ReadOnlyMode(),
+		"started":   started,
+		"duration":  time.Since(started),
+	}
+	if len(targets) > 0 {
+		var names []string
+		for _, target := range targets {
+			names = append(names, target.Name)
+		}
+		metadata["targets"] = names
+		metadata["db_name"] = strings.Join(names, ", ")
+		metadata["status"] = fmt.Sprintf("%s - %s", metadata["status"], healthcheck.StatusTargets(runs))
 	}
 	rs := report.Set{Elements: elements, Metadata: metadata}
 
 	// Load template if provided
 	var template string
-	if templatePath != "" {
-		data, readErr := ioutil.ReadFile(templatePath)
+	if options.TemplatePath != "" {
+		data, readErr := ioutil.ReadFile(options.TemplatePath)
 		if readErr != nil {
-			log.Fatal("Failed to read template: ", err)
+			return configError("Failed to read template: %v", readErr)
 		}
 		template = string(data)
 	} else {
@@ -73,53 +176,83 @@ func healthcheckRunner(config *config.Config, healthcheckPath string, reportPath
 	}
 
 	// Write report to file
-	if reportPath != "" {
+	if options.ReportPath != "" {
 		prr := report.NewPongo2ReportRunnerFromString(template, true)
 		reader, _ := prr.ReportReader(rs)
-		fhr := report.FileHandler{Filename: reportPath}
+		fhr := report.FileHandler{Filename: options.ReportPath}
 		err = fhr.HandleReport(reader)
 		if err != nil {
 			log.Error("error writing report to file: ", err)
 		}
 	}
 
+	// Write JUnit XML to file
+	if options.JUnitPath != "" {
+		reader, err := report.JUnitReportRunner{}.ReportReader(rs)
+		if err == nil {
+			err = report.FileHandler{Filename: options.JUnitPath}.HandleReport(reader)
+		}
+		if err != nil {
+			log.Error("error writing junit report to file: ", err)
+		}
+	}
+
+	// Stream TAP to stdout
+	if options.TAP {
+		reader, err := report.TAPReportRunner{}.ReportReader(rs)
+		if err == nil {
+			err = report.PrintHandler{}.HandleReport(reader)
+		}
+		if err != nil {
+			log.Error("error writing TAP stream: ", err)
+		}
+	}
+
+	// Export metrics to the node_exporter textfile collector
+	if options.PrometheusDir != "" {
+		reader, err := report.PrometheusReportRunner{}.ReportReader(rs)
+		if err == nil {
+			err = report.TextfileHandler{Directory: options.PrometheusDir, Filename: textfileName(healthChecks.Name)}.HandleReport(reader)
+		}
+		if err != nil {
+			log.Error("error writing prometheus metrics: ", err)
+		}
+	}
+
 	// Email report
-	if emailListPath != "" {
+	if options.EmailListPath != "" {
 		prr := report.NewPongo2ReportRunnerFromString(template, true)
-		df, err := report.ReadDistributionFormatYAMLFromFile(emailListPath)
+		df, err := report.ReadDistributionFormatYAMLFromFile(options.EmailListPath)
 		if err != nil {
-			log.Fatal("Failed to read distribution format: ", err)
+			return configError("Failed to read distribution format: %v", err)
 		}
 
 		for _, level := range report.LogLevelArray {
 
 			subjectStr := healthcheck.SubjectHealthcheck(healthChecks.Name, config.PgDatabase, config.PgHost, level, numErrors, numWarnings, fatal)
+			if len(targets) > 0 {
+				subjectStr = healthcheck.SubjectHealthcheckTargets(healthChecks.Name, strings.ToUpper(level)+" level", runs)
+			}
 
 			logFilteredSet := report.FilterReportSet(rs, level)
-			reader, _ := prr.ReportReader(logFilteredSet)
-			recipients := df.GetEmails(level)
-
-			if recipients != nil && len(recipients) != 0 && len(logFilteredSet.Elements) != 0 {
-				log.Infof("Send %s to: %v", subjectStr, recipients)
-				ehr := report.EmailHandler{
-					SMTPHost:    config.SMTPHost,
-					SMTPPort:    config.SMTPPort,
-					SenderEmail: config.SMTPEmail,
-					SenderName:  config.SMTPName,
-					Subject:     subjectStr,
-					Recipients:  recipients,
-					HTML:        true,
-				}
-				err = ehr.HandleReport(reader)
-				if err != nil {
-					log.Error("Failed to email report: ", err)
-				}
+			emailReport(config, prr, logFilteredSet, subjectStr, df.GetEmails(level))
+		}
+
+		for tag := range df.Tags {
+
+			tagFilteredSet := report.FilterReportSetByTag(rs, tag)
+			tagErrors, tagWarnings, tagFatal := healthcheck.EvaluateReportSet(tagFilteredSet)
+			subjectStr := healthcheck.SubjectHealthcheckTag(healthChecks.Name, config.PgDatabase, config.PgHost, tag, tagErrors, tagWarnings, tagFatal)
+			if len(targets) > 0 {
+				subjectStr = healthcheck.SubjectHealthcheckTargets(healthChecks.Name, tag+" tag", runs)
 			}
+
+			emailReport(config, prr, tagFilteredSet, subjectStr, df.GetTagEmails(tag))
 		}
 	}
 
-	if hcSchema != "" && hcTable != "" {
-		prr := report.NewPongo2ReportRunnerFromString(healthcheck.TemplateHealthcheckPostgres, false)
+	if options.Schema != "" && options.Table != "" {
+		prr := report.NewPongo2ReportRunnerFromString(healthcheck.TemplateHealthcheckResults(database.DialectOf(cxn)), false)
 		pgr := report.PGHandler{Cxn: cxn}
 		reader, err := prr.ReportReader(rs)
 		err = pgr.HandleReport(reader)
@@ -128,14 +261,292 @@ func healthcheckRunner(config *config.Config, healthcheckPath string, reportPath
 		}
 	}
 
-	if numErrors > 0 || fatal == true {
-		// log.Panic("Healthchecks Failed:\n", spew.Sdump(HCerrs))
-		err := errors.New("Healthchecks Failed")
+	status := healthcheck.StatusHealthchecks(numErrors, numWarnings, fatal)
+	if code := healthcheck.ExitCode(numErrors, numWarnings, fatal, threshold); code != healthcheck.ExitPass {
+		return cli.NewExitError("Healthchecks Failed: "+status, code)
+	}
+	if status != "PASS" {
+		log.Infof("Healthchecks %s, below --fail-on %s", status, options.FailOn)
+	}
+	return nil
+}
+
+// splitTags reads a comma separated list of tags, ignoring spaces around them
+func splitTags(list string) (tags []string) {
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return
+}
+
+// readTrailingFlags sets the flags given after the arguments of a command
+// with subcommands, which stops reading flags at its first argument, and
+// returns the arguments
+func readTrailingFlags(c *cli.Context, flags []cli.Flag) (args []string, err error) {
+	// whether each flag, by every one of its names, is a bool flag
+	isBool := make(map[string]bool)
+	for _, flag := range flags {
+		_, ok := flag.(cli.BoolFlag)
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			isBool[strings.TrimSpace(name)] = ok
+		}
+	}
+
+	trailing := c.Args()
+	for i := 0; i < len(trailing); i++ {
+		arg := trailing[i]
+		if arg == "--" {
+			return append(args, trailing[i+1:]...), nil
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			args = append(args, arg)
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		value := "true"
+		if split := strings.Index(name, "="); split >= 0 {
+			name, value = name[:split], name[split+1:]
+		}
+		boolFlag, known := isBool[name]
+		if !known {
+			return nil, fmt.Errorf("flag provided but not defined: %s", arg)
+		}
+		if !boolFlag && !strings.Contains(arg, "=") {
+			if i+1 == len(trailing) {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			value = trailing[i]
+		}
+		if err = c.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid flag %s: %v", arg, err)
+		}
+	}
+	return args, nil
+}
+
+// textfileName returns the name of the prometheus textfile of a suite, so
+// several suites can share a textfile collector directory
+func textfileName(suite string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(suite))
+	return "rhobot_" + strings.Trim(name, "_") + ".prom"
+}
+
+// pingTimeout limits how long to wait to find out if the database is available
+const pingTimeout = 5 * time.Second
+
+// healthcheckLinter prints every problem in the healthcheck files as text or
+// json, preparing the queries when the database is available
+func healthcheckLinter(config *config.Config, paths []string, vars map[string]string, format string) (int, error) {
+	if format != "text" && format != "json" {
+		return 0, fmt.Errorf("unknown lint format %q, use text or json", format)
+	}
+
+	var cxn *sql.DB
+	db, err := database.Open(config.DBURI())
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		log.Warnf("Database not available, queries will not be prepared: %v", err)
+	} else {
+		cxn = db
+	}
+
+	problems := healthcheck.Lint(paths, vars, cxn)
+	if format == "json" {
+		if problems == nil {
+			problems = []healthcheck.Problem{}
+		}
+		data, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	}
+	return len(problems), nil
+}
+
+// printSchema prints the JSON Schema of a healthcheck or distribution list file
+func printSchema(kind string) error {
+	var schema jsonschema.Schema
+	switch kind {
+	case "healthcheck":
+		schema = healthcheck.FormatSchema()
+	case "distribution":
+		schema = report.DistributionSchema()
+	case "schedule":
+		schema = schedule.FormatSchema()
+	case "serve":
+		schema = server.FormatSchema()
+	default:
+		return fmt.Errorf("unknown schema %q, use healthcheck, distribution, schedule or serve", kind)
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
 		return err
 	}
+	fmt.Println(string(data))
 	return nil
 }
 
+// healthcheckDaemon runs the healthchecks of a schedule file until it gets
+// SIGINT or SIGTERM, reloading the schedule file on SIGHUP. Runs in flight
+// are waited for when stopping, unless a second signal arrives, which
+// exits with ExitFatal.
+func healthcheckDaemon(config *config.Config, path string) error {
+	format, err := schedule.ReadScheduleFromFile(path)
+	if err != nil {
+		return configError("Failed to read schedule: %v", err)
+	}
+
+	scheduler := schedule.NewScheduler(func(entry schedule.Entry) error {
+		entryConfig := *config
+		if entry.DBURI != "" {
+			entryConfig.SetDBURI(entry.DBURI)
+		}
+		return healthcheckRunner(&entryConfig, scheduledOptions(entry))
+	})
+	if err = scheduler.Load(format.Schedules); err != nil {
+		return configError("Failed to schedule healthchecks: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Info("Reloading schedule from ", path)
+		reloaded, err := schedule.ReadScheduleFromFile(path)
+		if err == nil {
+			err = scheduler.Load(reloaded.Schedules)
+		}
+		if err != nil {
+			log.Errorf("Keeping the current schedule, failed to reload: %v", err)
+		}
+	}
+
+	log.Infof("Shutting down, waiting for %v", scheduler.Running())
+	stopped := make(chan bool)
+	go func() {
+		scheduler.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case sig := <-signals:
+		return cli.NewExitError(fmt.Sprintf("Stopped by %v, abandoning %v", sig, scheduler.Running()), healthcheck.ExitFatal)
+	}
+}
+
+// shutdownTimeout limits how long serving waits for requests in flight when stopping
+const shutdownTimeout = time.Minute
+
+// readHeaderTimeout limits how long serving waits for a client to send its request headers
+const readHeaderTimeout = 10 * time.Second
+
+// healthcheckServer serves the suites of a serve file at /healthz/{suite}
+// until it gets SIGINT or SIGTERM
+func healthcheckServer(config *config.Config, path string, listen string) error {
+	format, err := server.ReadServeFromFile(path)
+	if err != nil {
+		return configError("Failed to read serve file: %v", err)
+	}
+	handler, err := server.New(format.Suites, config.DBURI())
+	if err != nil {
+		return configError("Failed to load suites: %v", err)
+	}
+	defer handler.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz/", handler)
+	httpServer := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.ListenAndServe()
+	}()
+	for _, suite := range format.Suites {
+		log.Infof("Serving %s at http://%s/healthz/%s", suite.Healthchecks, listen, suite.Name)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err = <-served:
+		return configError("Failed to serve: %v", err)
+	case sig := <-signals:
+		log.Infof("Shutting down on %v", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
+
+// scheduledOptions returns the healthcheck options of a scheduled entry
+func scheduledOptions(entry schedule.Entry) healthcheckOptions {
+	return healthcheckOptions{
+		HealthcheckPath: entry.Healthchecks,
+		ReportPath:      entry.Report,
+		JUnitPath:       entry.JUnit,
+		PrometheusDir:   entry.Prometheus,
+		EmailListPath:   entry.Email,
+		Schema:          entry.Schema,
+		Table:           entry.Table,
+		Vars:            entry.Vars,
+		Tags:            entry.Tags,
+		SkipTags:        entry.SkipTags,
+		TargetsPath:     entry.Targets,
+		FailOn:          entry.FailOn,
+	}
+}
+
+// emailReport sends a report to recipients, when there is anything to send
+func emailReport(config *config.Config, prr report.Runner, rs report.Set, subjectStr string, recipients []string) {
+	if len(recipients) == 0 || len(rs.Elements) == 0 {
+		return
+	}
+
+	reader, _ := prr.ReportReader(rs)
+	log.Infof("Send %s to: %v", subjectStr, recipients)
+	ehr := report.EmailHandler{
+		SMTPHost:    config.SMTPHost,
+		SMTPPort:    config.SMTPPort,
+		SenderEmail: config.SMTPEmail,
+		SenderName:  config.SMTPName,
+		Subject:     subjectStr,
+		Recipients:  recipients,
+		HTML:        true,
+	}
+	err := ehr.HandleReport(reader)
+	if err != nil {
+		log.Error("Failed to email report: ", err)
+	}
+}
+
 func getArtifact(gocdServer *gocd.Server, pipeline string, stage string, job string,
 	pipelineRun string, stageRun string, artifactPath string, artifactSavePath string) {
 
@@ -13,6 +13,7 @@ require (
 	github.com/lib/pq v0.0.0-20170810061220-e42267488fe3
 	github.com/onsi/ginkgo v1.14.2 // indirect
 	github.com/onsi/gomega v1.10.4 // indirect
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.7.0 // indirect
 	github.com/urfave/cli v1.20.1-0.20170813145949-f017f86fccc5
 	github.com/vanng822/css v0.0.0-20161108042044-00dd67448c5d // indirect
@@ -23,4 +24,6 @@ require (
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 	gopkg.in/yaml.v2 v2.3.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.20.4
 )
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -23,6 +24,9 @@ type Config struct {
 	PgPassword string
 	PgSSLMode  string
 
+	// dbURI is used as is for databases other than Postgres
+	dbURI string
+
 	GOCDHost     string
 	GOCDPort     string
 	GOCDUser     string
@@ -157,25 +161,39 @@ func (config *Config) SetLogLevel(level string) {
 	config.UpdateLogger()
 }
 
-// SetDBURI extracts Postgres connection variables from a DB URI
+// SetDBURI extracts Postgres connection variables from a DB URI. URIs for
+// other databases, such as sqlite:///path/to/file.db, are kept as they are.
 func (config *Config) SetDBURI(dbURI string) {
-	dbURIRegex := regexp.MustCompile(`postgres://(?P<pg_user>\w+):(?P<pg_password>.+?)@(?P<pg_host>\w+):(?P<pg_port>\w+)/(?P<pg_database>\w+).*`)
+	parts := strings.SplitN(dbURI, ":", 2)
+	if len(parts) == 2 && parts[0] != "postgres" && parts[0] != "postgresql" {
+		config.dbURI = dbURI
+		config.PgHost = ""
+		config.PgDatabase = strings.TrimPrefix(strings.SplitN(parts[1], "?", 2)[0], "//")
+		return
+	}
+	config.dbURI = ""
+
+	dbURIRegex := regexp.MustCompile(`postgres(?:ql)?://(?P<pg_user>\w+):(?P<pg_password>.+?)@(?P<pg_host>\w+):(?P<pg_port>\w+)/(?P<pg_database>\w+).*`)
 
 	match := dbURIRegex.FindStringSubmatch(dbURI)
 	if match == nil {
 		log.Error("Unable to set DB connection parameters, invalid DB URI!")
-	} else if len(match) < 4 {
-		log.Error("Unable to set DB connection parameters, too few regex matches with DB URI!")
+		return
 	}
 
 	config.PgUser = match[1]
 	config.PgPassword = match[2]
 	config.PgHost = match[3]
-	config.PgDatabase = match[4]
+	config.PgPort = match[4]
+	config.PgDatabase = match[5]
 }
 
 // DBURI generates a DB URI from the proper configruation options
 func (config *Config) DBURI() (dbURI string) {
+	if config.dbURI != "" {
+		return config.dbURI
+	}
+
 	parsedURI := make(map[string]interface{})
 
 	//URL Encode Postgres variables
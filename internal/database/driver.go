@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// Dialect is the flavour of SQL spoken by a database
+type Dialect string
+
+// Dialects of the databases rhobot can connect to
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// Sessions is true when the dialect supports SET LOCAL session settings
+func (dialect Dialect) Sessions() bool {
+	return dialect == Postgres
+}
+
+// Driver opens a database for the URI schemes it is registered under
+type Driver struct {
+	// Name is the database/sql driver name
+	Name    string
+	Dialect Dialect
+	// DSN turns a URI into the data source name given to the driver
+	DSN func(uri string) string
+}
+
+// drivers maps URI schemes to the driver that opens them
+var drivers = map[string]Driver{}
+
+func init() {
+	postgres := Driver{Name: "postgres", Dialect: Postgres, DSN: func(uri string) string { return uri }}
+	Register("postgres", postgres)
+	Register("postgresql", postgres)
+
+	sqlite := Driver{Name: "sqlite", Dialect: SQLite, DSN: sqliteDSN}
+	Register("sqlite", sqlite)
+	Register("sqlite3", sqlite)
+	Register("file", Driver{Name: "sqlite", Dialect: SQLite, DSN: func(uri string) string { return uri }})
+}
+
+// Register makes a driver available for URIs starting with scheme:
+func Register(scheme string, driver Driver) {
+	drivers[strings.ToLower(scheme)] = driver
+}
+
+// Scheme returns the scheme of a database URI, postgres when there is none
+func Scheme(uri string) string {
+	split := strings.Index(uri, ":")
+	if split < 1 {
+		return "postgres"
+	}
+	return strings.ToLower(uri[:split])
+}
+
+// Lookup finds the driver registered for a URI's scheme
+func Lookup(uri string) (Driver, error) {
+	driver, ok := drivers[Scheme(uri)]
+	if !ok {
+		return driver, fmt.Errorf("no database driver for %q URIs", Scheme(uri))
+	}
+	return driver, nil
+}
+
+// Open returns a connection to the database at uri, using the driver for its scheme
+func Open(uri string) (*sql.DB, error) {
+	driver, err := Lookup(uri)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(driver.Name, driver.DSN(uri))
+}
+
+// DialectOf returns the dialect of an open connection. Connections made by
+// drivers rhobot does not know about are assumed to speak Postgres.
+func DialectOf(cxn *sql.DB) Dialect {
+	if _, ok := cxn.Driver().(*sqlite.Driver); ok {
+		return SQLite
+	}
+	return Postgres
+}
+
+// sqliteDSN strips the sqlite:// prefix, leaving the file name and any
+// query parameters, so sqlite:///var/lib/checks.db opens /var/lib/checks.db
+func sqliteDSN(uri string) string {
+	dsn := uri[strings.Index(uri, ":")+1:]
+	return strings.TrimPrefix(dsn, "//")
+}
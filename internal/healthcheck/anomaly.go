@@ -0,0 +1,172 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AnomalyMethods are the statistics a result can be flagged as anomalous by
+var AnomalyMethods = []string{"zscore", "mad"}
+
+const (
+	defaultAnomalyWindow     = 30
+	defaultAnomalyMinHistory = 5
+	defaultAnomalyThreshold  = 3
+	// madScale makes the median absolute deviation comparable to a standard deviation
+	madScale = 0.6745
+)
+
+// Anomaly flags a result that is far from the results of earlier runs.
+// With the zscore method the band is mean ± threshold standard deviations,
+// with mad it is median ± threshold scaled median absolute deviations.
+// The band is at least MinSpread either side of its center, and a history
+// that does not vary is not alerted on without one.
+type Anomaly struct {
+	Method     string  `yaml:"method,omitempty"`
+	Window     int     `yaml:"window,omitempty"`
+	MinHistory int     `yaml:"min_history,omitempty"`
+	Threshold  float64 `yaml:"threshold,omitempty"`
+	MinSpread  float64 `yaml:"min_spread,omitempty"`
+	Low        string  `yaml:"low,omitempty" jsonschema:"-"`
+	High       string  `yaml:"high,omitempty" jsonschema:"-"`
+}
+
+// validate checks the anomaly settings can be used
+func (anomaly Anomaly) validate() error {
+	if anomaly.Method != "" {
+		known := false
+		for _, method := range AnomalyMethods {
+			known = known || strings.ToLower(anomaly.Method) == method
+		}
+		if !known {
+			return fmt.Errorf("unknown anomaly method %q", anomaly.Method)
+		}
+	}
+	if anomaly.Window < 0 || anomaly.MinHistory < 0 || anomaly.Threshold < 0 || anomaly.MinSpread < 0 {
+		return fmt.Errorf("anomaly window, min_history, threshold and min_spread can not be negative")
+	}
+	if anomaly.Window > 0 && anomaly.MinHistory > anomaly.Window {
+		return fmt.Errorf("anomaly min_history %d is larger than its window %d", anomaly.MinHistory, anomaly.Window)
+	}
+	return nil
+}
+
+// loadAnomaly computes the expected band from the healthcheck's history,
+// leaving it unset until there are at least MinHistory earlier results
+func (healthCheck *SQLHealthCheck) loadAnomaly(ctx context.Context, source HistorySource) error {
+	anomaly := *healthCheck.Anomaly
+	healthCheck.Anomaly = &anomaly
+	if anomaly.Method == "" {
+		anomaly.Method = "zscore"
+	}
+	if anomaly.Window == 0 {
+		anomaly.Window = defaultAnomalyWindow
+	}
+	if anomaly.MinHistory == 0 {
+		anomaly.MinHistory = defaultAnomalyMinHistory
+	}
+	if anomaly.Threshold == 0 {
+		anomaly.Threshold = defaultAnomalyThreshold
+	}
+
+	history, err := healthCheck.readHistory(ctx, source, anomaly.Window)
+	if err != nil {
+		return err
+	}
+	if len(history) < anomaly.MinHistory {
+		log.Infof("healthcheck %q has %d of %d results needed for anomaly detection",
+			healthCheck.Title, len(history), anomaly.MinHistory)
+		return nil
+	}
+
+	values := make([]float64, len(history))
+	for i, value := range history {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("history value %q is not numeric", value)
+		}
+		values[i] = number
+	}
+
+	low, high := anomalyBand(strings.ToLower(anomaly.Method), values, anomaly.Threshold)
+	if center := (low + high) / 2; high-center < anomaly.MinSpread {
+		low, high = center-anomaly.MinSpread, center+anomaly.MinSpread
+	}
+	if low == high {
+		log.Infof("healthcheck %q has the same result in its history, set a min_spread to alert on changes",
+			healthCheck.Title)
+		return nil
+	}
+	anomaly.Low = strconv.FormatFloat(low, 'g', -1, 64)
+	anomaly.High = strconv.FormatFloat(high, 'g', -1, 64)
+	healthCheck.Expected = fmt.Sprintf("%s to %s (%s)", roundBand(low), roundBand(high), anomaly.Method)
+	return nil
+}
+
+// anomalyBand returns the range of values that are not anomalous
+func anomalyBand(method string, values []float64, threshold float64) (float64, float64) {
+	if method == "mad" {
+		center := median(values)
+		deviations := make([]float64, len(values))
+		for i, value := range values {
+			deviations[i] = math.Abs(value - center)
+		}
+		spread := threshold * median(deviations) / madScale
+		return center - spread, center + spread
+	}
+
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	var deviation float64
+	if len(values) > 1 {
+		deviation = math.Sqrt(squares / float64(len(values)-1))
+	}
+	return mean - threshold*deviation, mean + threshold*deviation
+}
+
+// roundBand renders a band limit for the report
+func roundBand(limit float64) string {
+	return strconv.FormatFloat(math.Round(limit*1e4)/1e4, 'f', -1, 64)
+}
+
+// median returns the middle of values, which must not be empty
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// checkAnomaly is true when actual lies within the expected band,
+// or when there is not yet enough history to compute one
+func (healthCheck SQLHealthCheck) checkAnomaly(actual string) (bool, error) {
+	anomaly := healthCheck.Anomaly
+	if anomaly.Low == "" || anomaly.High == "" {
+		return true, nil
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(actual), 64)
+	if err != nil {
+		return false, fmt.Errorf("cannot check %q for anomalies as numeric", actual)
+	}
+	low, _ := strconv.ParseFloat(anomaly.Low, 64)
+	high, _ := strconv.ParseFloat(anomaly.High, 64)
+	return low <= value && value <= high, nil
+}
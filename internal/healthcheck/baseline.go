@@ -0,0 +1,211 @@
+package healthcheck
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/cfpb/rhobot/internal/database"
+)
+
+// Aggregates are the ways earlier results can be combined into a baseline
+var Aggregates = []string{"last", "avg", "min", "max"}
+
+// Baseline compares a healthcheck against the results of its earlier runs.
+// The aggregate of the last Runs results becomes the expected value, which is
+// compared with Operation, or must stay within MaxDecrease and MaxIncrease.
+type Baseline struct {
+	Runs        int      `yaml:"runs,omitempty"`
+	Aggregate   string   `yaml:"aggregate,omitempty"`
+	MaxDecrease string   `yaml:"max_decrease,omitempty"`
+	MaxIncrease string   `yaml:"max_increase,omitempty"`
+	History     []string `yaml:"history,omitempty"`
+}
+
+// HistorySource reads the actual values of earlier runs of a healthcheck in
+// a suite, most recent first
+type HistorySource interface {
+	History(ctx context.Context, suite, title string, runs int) ([]string, error)
+}
+
+// ResultsTable reads history from the table written by TemplateHealthcheckResults,
+// for a single target when Target is set
+type ResultsTable struct {
+	Cxn    *sql.DB
+	Schema string
+	Table  string
+	Target string
+}
+
+// History returns the actual values of the last runs of a healthcheck that executed,
+// or none when the results table has not been created yet
+func (table ResultsTable) History(ctx context.Context, suite, title string, runs int) (history []string, err error) {
+	exists, err := table.exists(ctx)
+	if err != nil || !exists {
+		return
+	}
+
+	query := fmt.Sprintf(`SELECT actual FROM %s.%s
+WHERE title = $1 AND executed = 'SUCCESS' AND coalesce(target, '') = $3 AND coalesce(suite, '') = $4
+ORDER BY "timestamp" DESC LIMIT $2`, quoteIdentifier(table.Schema), quoteIdentifier(table.Table))
+
+	rows, err := table.Cxn.QueryContext(ctx, query, title, runs, table.Target, suite)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var actual sql.NullString
+		if err = rows.Scan(&actual); err != nil {
+			return
+		}
+		history = append(history, actual.String)
+	}
+	err = rows.Err()
+	return
+}
+
+// exists is true once the results table has been created
+func (table ResultsTable) exists(ctx context.Context) (exists bool, err error) {
+	if database.DialectOf(table.Cxn) == database.SQLite {
+		query := fmt.Sprintf(`SELECT count(*) > 0 FROM %s.sqlite_master WHERE type = 'table' AND name = $1`,
+			quoteIdentifier(table.Schema))
+		err = table.Cxn.QueryRowContext(ctx, query, table.Table).Scan(&exists)
+		return
+	}
+	name := quoteIdentifier(table.Schema) + "." + quoteIdentifier(table.Table)
+	err = table.Cxn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+	return
+}
+
+// validate checks the baseline settings can be used
+func (baseline Baseline) validate() error {
+	if baseline.Runs < 0 {
+		return fmt.Errorf("baseline runs can not be negative")
+	}
+	if baseline.Aggregate != "" {
+		known := false
+		for _, aggregate := range Aggregates {
+			known = known || strings.ToLower(baseline.Aggregate) == aggregate
+		}
+		if !known {
+			return fmt.Errorf("unknown baseline aggregate %q", baseline.Aggregate)
+		}
+	}
+	for _, allowed := range []string{baseline.MaxDecrease, baseline.MaxIncrease} {
+		if _, err := parseTolerance(new(big.Rat), allowed); allowed != "" && err != nil {
+			return fmt.Errorf("baseline: %v", err)
+		}
+	}
+	return nil
+}
+
+// bounded is true when the baseline limits how far a result may move
+func (baseline Baseline) bounded() bool {
+	return baseline.MaxDecrease != "" || baseline.MaxIncrease != ""
+}
+
+// loadBaseline sets Expected from the healthcheck's history. Without history
+// there is nothing to compare against and the healthcheck only has to run.
+func (healthCheck *SQLHealthCheck) loadBaseline(ctx context.Context, source HistorySource) error {
+	baseline := *healthCheck.Baseline
+	healthCheck.Baseline = &baseline
+	if baseline.Runs == 0 {
+		baseline.Runs = 1
+	}
+
+	var err error
+	baseline.History, err = healthCheck.readHistory(ctx, source, baseline.Runs)
+	if err != nil {
+		return err
+	}
+	if len(baseline.History) == 0 {
+		log.Infof("no baseline yet for healthcheck %q", healthCheck.Title)
+		return nil
+	}
+
+	healthCheck.Expected, err = aggregate(baseline.Aggregate, baseline.History)
+	return err
+}
+
+// readHistory returns up to runs earlier results of the healthcheck in its suite.
+// Having no results table to read from is not an error, failing to read one is.
+func (healthCheck SQLHealthCheck) readHistory(ctx context.Context, source HistorySource, runs int) ([]string, error) {
+	if source == nil {
+		log.Warnf("healthcheck %q needs history but there is no results table to read it from", healthCheck.Title)
+		return nil, nil
+	}
+	history, err := source.History(ctx, healthCheck.Suite, healthCheck.Title, runs)
+	if err != nil {
+		return nil, fmt.Errorf("could not read history: %v", err)
+	}
+	return history, nil
+}
+
+// checkBaseline evaluates a result against the baseline's max_decrease and max_increase
+func (healthCheck SQLHealthCheck) checkBaseline(actual string) (bool, error) {
+	baseline := healthCheck.Baseline
+	if len(baseline.History) == 0 {
+		return true, nil
+	}
+	center, okCenter := new(big.Rat).SetString(strings.TrimSpace(healthCheck.Expected))
+	value, okValue := new(big.Rat).SetString(strings.TrimSpace(actual))
+	if !okCenter || !okValue {
+		return false, fmt.Errorf("cannot compare %q with baseline %q as numeric", actual, healthCheck.Expected)
+	}
+
+	difference := new(big.Rat).Sub(value, center)
+	if baseline.MaxDecrease != "" && difference.Sign() < 0 {
+		limit, err := parseTolerance(center, baseline.MaxDecrease)
+		if err != nil || new(big.Rat).Neg(difference).Cmp(limit) > 0 {
+			return false, err
+		}
+	}
+	if baseline.MaxIncrease != "" && difference.Sign() > 0 {
+		limit, err := parseTolerance(center, baseline.MaxIncrease)
+		if err != nil || difference.Cmp(limit) > 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// aggregate combines earlier results, most recent first, into a single value
+func aggregate(how string, history []string) (string, error) {
+	how = strings.ToLower(how)
+	if how == "" || how == "last" {
+		return history[0], nil
+	}
+
+	var result *big.Rat
+	sum := new(big.Rat)
+	for _, value := range history {
+		number, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+		if !ok {
+			return "", fmt.Errorf("baseline value %q is not numeric", value)
+		}
+		sum.Add(sum, number)
+		if result == nil ||
+			(how == "min" && number.Cmp(result) < 0) ||
+			(how == "max" && number.Cmp(result) > 0) {
+			result = number
+		}
+	}
+	if how == "avg" {
+		result = sum.Quo(sum, big.NewRat(int64(len(history)), 1))
+	}
+	return formatRat(result), nil
+}
+
+// formatRat renders a number without a fraction when it is whole
+func formatRat(number *big.Rat) string {
+	if number.IsInt() {
+		return number.RatString()
+	}
+	return strings.TrimRight(number.FloatString(6), "0")
+}
@@ -0,0 +1,244 @@
+package healthcheck
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DataTypes are the types a healthcheck result can be compared as
+var DataTypes = []string{"integer", "numeric", "timestamp", "interval", "boolean", "text"}
+
+// timestampLayouts are the timestamp formats accepted for the timestamp type
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// intervalTime matches the [-]hh:mm[:ss[.fff]] part of a postgres interval
+var intervalTime = regexp.MustCompile(`^([+-])?(\d+):(\d{1,2})(?::(\d{1,2}(?:\.\d+)?))?$`)
+
+// intervalUnits maps interval units to microseconds, using postgres' 30 day month
+var intervalUnits = map[string]int64{
+	"microsecond": 1, "microseconds": 1, "us": 1,
+	"millisecond": 1e3, "milliseconds": 1e3, "ms": 1e3,
+	"second": 1e6, "seconds": 1e6, "sec": 1e6, "secs": 1e6, "s": 1e6,
+	"minute": 6e7, "minutes": 6e7, "min": 6e7, "mins": 6e7, "m": 6e7,
+	"hour": 36e8, "hours": 36e8, "hr": 36e8, "hrs": 36e8, "h": 36e8,
+	"day": 864e8, "days": 864e8, "d": 864e8,
+	"week": 6048e8, "weeks": 6048e8, "w": 6048e8,
+	"mon": 2592e9, "mons": 2592e9, "month": 2592e9, "months": 2592e9,
+	"year": 31104e9, "years": 31104e9, "y": 31104e9,
+}
+
+// ValidDataType reports whether dataType is one of DataTypes
+func ValidDataType(dataType string) bool {
+	for _, known := range DataTypes {
+		if strings.ToLower(dataType) == known {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectType maps a database column type name to one of DataTypes
+func DetectType(databaseType string) string {
+	switch strings.ToUpper(databaseType) {
+	case "INT2", "INT4", "INT8", "OID", "INT", "INTEGER", "SMALLINT", "BIGINT":
+		return "integer"
+	case "NUMERIC", "DECIMAL", "FLOAT4", "FLOAT8", "REAL", "DOUBLE", "DOUBLE PRECISION":
+		return "numeric"
+	case "TIMESTAMP", "TIMESTAMPTZ", "DATE", "DATETIME":
+		return "timestamp"
+	case "INTERVAL":
+		return "interval"
+	case "BOOL", "BOOLEAN":
+		return "boolean"
+	}
+	return "text"
+}
+
+// compareOperation evaluates "expected OPERATION actual" for the given type
+func compareOperation(operation string, expected string, actual string, dataType string) (bool, error) {
+	cmp, err := compareValues(expected, actual, dataType)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(operation) {
+	case "eq":
+		return cmp == 0, nil
+	case "ne":
+		return cmp != 0, nil
+	case "lt":
+		return cmp < 0, nil
+	case "le":
+		return cmp <= 0, nil
+	case "gt":
+		return cmp > 0, nil
+	case "ge":
+		return cmp >= 0, nil
+	case "":
+		log.Info("opperation not specified, checking if equals")
+		return cmp == 0, nil
+	default:
+		return false, fmt.Errorf("unknown operation %q", operation)
+	}
+}
+
+// compareValues returns -1, 0 or 1 when a is less than, equal to or greater than b
+func compareValues(a string, b string, dataType string) (int, error) {
+	switch strings.ToLower(dataType) {
+	case "integer":
+		x, okA := new(big.Int).SetString(strings.TrimSpace(a), 10)
+		y, okB := new(big.Int).SetString(strings.TrimSpace(b), 10)
+		if !okA || !okB {
+			return 0, fmt.Errorf("cannot compare %q and %q as integer", a, b)
+		}
+		return x.Cmp(y), nil
+	case "numeric":
+		x, okA := new(big.Rat).SetString(strings.TrimSpace(a))
+		y, okB := new(big.Rat).SetString(strings.TrimSpace(b))
+		if !okA || !okB {
+			return 0, fmt.Errorf("cannot compare %q and %q as numeric", a, b)
+		}
+		return x.Cmp(y), nil
+	case "timestamp":
+		x, errA := parseTimestamp(a)
+		y, errB := parseTimestamp(b)
+		if errA != nil || errB != nil {
+			return 0, fmt.Errorf("cannot compare %q and %q as timestamp", a, b)
+		}
+		if x.Before(y) {
+			return -1, nil
+		} else if x.After(y) {
+			return 1, nil
+		}
+		return 0, nil
+	case "interval":
+		x, errA := parseInterval(a)
+		y, errB := parseInterval(b)
+		if errA != nil || errB != nil {
+			return 0, fmt.Errorf("cannot compare %q and %q as interval", a, b)
+		}
+		return x.Cmp(y), nil
+	case "boolean":
+		x, errA := parseBoolean(a)
+		y, errB := parseBoolean(b)
+		if errA != nil || errB != nil {
+			return 0, fmt.Errorf("cannot compare %q and %q as boolean", a, b)
+		}
+		if x == y {
+			return 0, nil
+		} else if !x {
+			return -1, nil
+		}
+		return 1, nil
+	case "", "text":
+		return strings.Compare(a, b), nil
+	}
+	return 0, fmt.Errorf("unknown type %q", dataType)
+}
+
+// parseValue checks that value can be read as dataType
+func parseValue(value string, dataType string) error {
+	_, err := compareValues(value, value, dataType)
+	return err
+}
+
+// parseTimestamp reads a timestamp in any of timestampLayouts
+func parseTimestamp(value string) (t time.Time, err error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timestampLayouts {
+		if t, err = time.Parse(layout, value); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// parseBoolean reads postgres and yaml style booleans
+func parseBoolean(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(value))
+}
+
+// parseInterval reads a postgres interval ("1 day 02:00:00") or a go duration ("26h")
+// and returns its length in seconds
+func parseInterval(value string) (*big.Rat, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil {
+		return new(big.Rat).SetFrac64(int64(d), int64(time.Second)), nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(value, "@"))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty interval")
+	}
+
+	ago := false
+	if strings.ToLower(fields[len(fields)-1]) == "ago" {
+		ago = true
+		fields = fields[:len(fields)-1]
+	}
+
+	total := new(big.Rat)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		if match := intervalTime.FindStringSubmatch(field); match != nil {
+			seconds, _ := new(big.Rat).SetString(match[2])
+			seconds.Mul(seconds, big.NewRat(3600, 1))
+			minutes, _ := new(big.Rat).SetString(match[3])
+			seconds.Add(seconds, minutes.Mul(minutes, big.NewRat(60, 1)))
+			if match[4] != "" {
+				secs, _ := new(big.Rat).SetString(match[4])
+				seconds.Add(seconds, secs)
+			}
+			if match[1] == "-" {
+				seconds.Neg(seconds)
+			}
+			total.Add(total, seconds)
+			continue
+		}
+
+		number, unit := field, ""
+		if split := strings.IndexFunc(field, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+		}); split > 0 {
+			number, unit = field[:split], field[split:]
+		} else if i+1 < len(fields) {
+			i++
+			unit = fields[i]
+		}
+
+		amount, ok := new(big.Rat).SetString(strings.TrimPrefix(number, "+"))
+		if !ok {
+			return nil, fmt.Errorf("invalid interval %q", value)
+		}
+		scale, ok := intervalUnits[strings.ToLower(unit)]
+		if !ok {
+			return nil, fmt.Errorf("invalid interval unit %q", unit)
+		}
+		total.Add(total, amount.Mul(amount, big.NewRat(scale, 1e6)))
+	}
+
+	if ago {
+		total.Neg(total)
+	}
+	return total, nil
+}
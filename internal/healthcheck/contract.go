@@ -0,0 +1,257 @@
+package healthcheck
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cfpb/rhobot/internal/database"
+)
+
+// Contract is the structure a schema healthcheck expects its table to have
+type Contract struct {
+	Columns           []ContractColumn `yaml:"columns" jsonschema:"required"`
+	PrimaryKey        []string         `yaml:"primary_key,omitempty"`
+	Indexes           []ContractIndex  `yaml:"indexes,omitempty"`
+	AllowExtraColumns bool             `yaml:"allow_extra_columns,omitempty"`
+}
+
+// ContractColumn is a column of a Contract. Its type and nullability
+// are only checked when they are given.
+type ContractColumn struct {
+	Name     string `yaml:"name" jsonschema:"required"`
+	Type     string `yaml:"type,omitempty"`
+	Nullable *bool  `yaml:"nullable,omitempty"`
+}
+
+// ContractIndex is an index of a Contract, found by its columns in order
+type ContractIndex struct {
+	Columns []string `yaml:"columns" jsonschema:"required"`
+	Unique  bool     `yaml:"unique,omitempty"`
+}
+
+// Drift is a difference between a table and its Contract
+type Drift struct {
+	Object   string
+	Expected string
+	Actual   string
+}
+
+// typeModifier matches the length or precision of a type, as in varchar(20)
+var typeModifier = regexp.MustCompile(`\s*\([^)]*\)`)
+
+// typeAliases maps type names to the name information_schema uses for them
+var typeAliases = map[string]string{
+	"int": "integer", "int4": "integer", "serial": "integer",
+	"int8": "bigint", "bigserial": "bigint", "int2": "smallint",
+	"varchar": "character varying", "char": "character", "bpchar": "character",
+	"bool": "boolean", "decimal": "numeric", "float4": "real", "float8": "double precision",
+	"timestamp": "timestamp without time zone", "timestamptz": "timestamp with time zone",
+	"time": "time without time zone", "timetz": "time with time zone",
+}
+
+// postgresContractQuery reads the columns and indexes of table $2 in schema $1,
+// or in the current schema when $1 is empty
+const postgresContractQuery = `SELECT 'column'::text AS object, c.column_name::text AS name, c.data_type::text AS type,
+	c.is_nullable::text AS nullable, ''::text AS is_unique, ''::text AS columns
+FROM information_schema.columns AS c
+WHERE c.table_schema::text = coalesce(nullif($1::text, ''), current_schema()) AND c.table_name::text = $2::text
+UNION ALL
+SELECT CASE WHEN i.indisprimary THEN 'primary_key' ELSE 'index' END, ic.relname::text, '', '',
+	CASE WHEN i.indisunique THEN 'YES' ELSE 'NO' END,
+	(SELECT string_agg(a.attname::text, ',' ORDER BY k.ord)
+		FROM unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute AS a ON a.attrelid = i.indrelid AND a.attnum = k.attnum)
+FROM pg_index AS i
+JOIN pg_class AS ic ON ic.oid = i.indexrelid
+JOIN pg_class AS tc ON tc.oid = i.indrelid
+JOIN pg_namespace AS n ON n.oid = tc.relnamespace
+WHERE n.nspname::text = coalesce(nullif($1::text, ''), current_schema()) AND tc.relname::text = $2::text`
+
+// sqliteContractQuery reads the columns and indexes of table $2 in schema $1
+const sqliteContractQuery = `SELECT 'column' AS object, name, type,
+	CASE WHEN "notnull" THEN 'NO' ELSE 'YES' END AS nullable, '' AS is_unique, '' AS columns
+FROM pragma_table_info($2, $1)
+UNION ALL
+SELECT 'primary_key', '', '', '', 'YES', group_concat(name, ',')
+FROM (SELECT name FROM pragma_table_info($2, $1) WHERE pk > 0 ORDER BY pk) HAVING count(*) > 0
+UNION ALL
+SELECT 'index', il.name, '', '', CASE WHEN il."unique" THEN 'YES' ELSE 'NO' END,
+	(SELECT group_concat(name, ',') FROM (SELECT ii.name FROM pragma_index_info(il.name, $1) AS ii ORDER BY ii.seqno))
+FROM pragma_index_list($2, $1) AS il WHERE il.origin <> 'pk'`
+
+// tableStructure is what the database reports about a table
+type tableStructure struct {
+	columns    map[string]tableColumn
+	order      []string
+	primaryKey string
+	indexes    []tableIndex
+}
+
+type tableColumn struct {
+	dataType string
+	nullable bool
+}
+
+type tableIndex struct {
+	columns string
+	unique  bool
+}
+
+// validate checks a contract declares columns, each once, and indexes with columns
+func (contract Contract) validate() error {
+	if len(contract.Columns) == 0 {
+		return errors.New("needs at least one column")
+	}
+	declared := make(map[string]bool)
+	for _, column := range contract.Columns {
+		if column.Name == "" {
+			return errors.New("every column needs a name")
+		}
+		if declared[column.Name] {
+			return fmt.Errorf("column %q is declared more than once", column.Name)
+		}
+		declared[column.Name] = true
+	}
+	for _, index := range contract.Indexes {
+		if len(index.Columns) == 0 {
+			return errors.New("every index needs columns")
+		}
+	}
+	return nil
+}
+
+// contractQuery returns the catalog query reading the table's structure
+// in the database of cxn, and its arguments
+func (healthCheck SQLHealthCheck) contractQuery(cxn *sql.DB) (string, []interface{}) {
+	schema, table := "", healthCheck.Table
+	if split := strings.LastIndex(table, "."); split >= 0 {
+		schema, table = table[:split], table[split+1:]
+	}
+	if database.DialectOf(cxn) == database.SQLite {
+		if schema == "" {
+			schema = "main"
+		}
+		return sqliteContractQuery, []interface{}{schema, table}
+	}
+	return postgresContractQuery, []interface{}{schema, table}
+}
+
+// checkContract compares the table structure read by contractQuery with the contract
+func (healthCheck *SQLHealthCheck) checkContract(result resultSet) {
+	healthCheck.Drifts = healthCheck.Schema.compare(readTableStructure(result))
+	healthCheck.Equal = len(healthCheck.Drifts) == 0
+	healthCheck.Actual = "matches"
+	if !healthCheck.Equal {
+		healthCheck.Actual = fmt.Sprintf("%d drift(s)", len(healthCheck.Drifts))
+	}
+}
+
+// readTableStructure reads the rows of a contract query
+func readTableStructure(result resultSet) tableStructure {
+	table := tableStructure{columns: make(map[string]tableColumn)}
+	for row := range result.rows {
+		name := result.value(row, 1)
+		switch result.value(row, 0) {
+		case "column":
+			table.columns[name] = tableColumn{dataType: result.value(row, 2), nullable: result.value(row, 3) == "YES"}
+			table.order = append(table.order, name)
+		case "primary_key":
+			table.primaryKey = result.value(row, 5)
+			table.indexes = append(table.indexes, tableIndex{columns: table.primaryKey, unique: true})
+		case "index":
+			table.indexes = append(table.indexes, tableIndex{columns: result.value(row, 5), unique: result.value(row, 4) == "YES"})
+		}
+	}
+	return table
+}
+
+// compare lists every way the table differs from the contract
+func (contract Contract) compare(table tableStructure) (drifts []Drift) {
+	if len(table.columns) == 0 {
+		return []Drift{{Object: "table", Expected: "present", Actual: "missing"}}
+	}
+
+	declared := make(map[string]bool)
+	for _, column := range contract.Columns {
+		declared[column.Name] = true
+		actual, ok := table.columns[column.Name]
+		if !ok {
+			drifts = append(drifts, Drift{Object: fmt.Sprintf("column %q", column.Name), Expected: "present", Actual: "missing"})
+			continue
+		}
+		if column.Type != "" && normalizeType(column.Type) != normalizeType(actual.dataType) {
+			drifts = append(drifts, Drift{Object: fmt.Sprintf("column %q type", column.Name),
+				Expected: column.Type, Actual: actual.dataType})
+		}
+		if column.Nullable != nil && *column.Nullable != actual.nullable {
+			drifts = append(drifts, Drift{Object: fmt.Sprintf("column %q nullable", column.Name),
+				Expected: fmt.Sprint(*column.Nullable), Actual: fmt.Sprint(actual.nullable)})
+		}
+	}
+
+	if !contract.AllowExtraColumns {
+		for _, name := range table.order {
+			if !declared[name] {
+				drifts = append(drifts, Drift{Object: fmt.Sprintf("column %q", name), Expected: "absent", Actual: "present"})
+			}
+		}
+	}
+
+	if len(contract.PrimaryKey) > 0 {
+		if expected := strings.Join(contract.PrimaryKey, ","); expected != table.primaryKey {
+			actual := table.primaryKey
+			if actual == "" {
+				actual = "none"
+			}
+			drifts = append(drifts, Drift{Object: "primary key", Expected: expected, Actual: actual})
+		}
+	}
+
+	for _, index := range contract.Indexes {
+		object := fmt.Sprintf("index on (%s)", strings.Join(index.Columns, ", "))
+		found, unique := false, false
+		for _, actual := range table.indexes {
+			if actual.columns == strings.Join(index.Columns, ",") {
+				found, unique = true, unique || actual.unique
+			}
+		}
+		switch {
+		case !found:
+			drifts = append(drifts, Drift{Object: object, Expected: "present", Actual: "missing"})
+		case index.Unique && !unique:
+			drifts = append(drifts, Drift{Object: object, Expected: "unique", Actual: "not unique"})
+		}
+	}
+	return
+}
+
+// normalizeType drops the length or precision of a type and names it the way
+// information_schema does, so varchar(20) and character varying compare equal
+func normalizeType(dataType string) string {
+	dataType = strings.ToLower(typeModifier.ReplaceAllString(dataType, ""))
+	dataType = strings.Join(strings.Fields(dataType), " ")
+	if alias, ok := typeAliases[dataType]; ok {
+		return alias
+	}
+	return dataType
+}
+
+// elements returns the results a healthcheck reports, one for every drift
+// from its schema contract, or else the healthcheck itself
+func (healthCheck SQLHealthCheck) elements() []SQLHealthCheck {
+	if len(healthCheck.Drifts) == 0 {
+		return []SQLHealthCheck{healthCheck}
+	}
+	var elements []SQLHealthCheck
+	for _, drift := range healthCheck.Drifts {
+		element := healthCheck
+		element.Title = fmt.Sprintf("%s: %s", healthCheck.Title, drift.Object)
+		element.Expected, element.Actual = drift.Expected, drift.Actual
+		element.Drifts = nil
+		elements = append(elements, element)
+	}
+	return elements
+}
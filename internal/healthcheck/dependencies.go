@@ -0,0 +1,108 @@
+package healthcheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckDependencies makes sure every depends_on refers to a known id
+// and that the dependencies between healthchecks do not form a cycle
+func (healthChecks *Format) CheckDependencies() error {
+	ids := make(map[string]int)
+	for i, test := range healthChecks.Tests {
+		if test.ID == "" {
+			continue
+		}
+		if j, ok := ids[test.ID]; ok {
+			return fmt.Errorf("healthcheck id %q is used by both %q and %q",
+				test.ID, healthChecks.Tests[j].Title, test.Title)
+		}
+		ids[test.ID] = i
+	}
+
+	for _, test := range healthChecks.Tests {
+		for _, dependency := range test.DependsOn {
+			if _, ok := ids[dependency]; !ok {
+				return fmt.Errorf("healthcheck %q depends on unknown id %q", test.Title, dependency)
+			}
+		}
+	}
+
+	// depth first search, a healthcheck seen again while still on the path is a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(healthChecks.Tests))
+	var path []string
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		test := healthChecks.Tests[i]
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("healthcheck dependencies form a cycle: %s -> %s",
+				strings.Join(path, " -> "), test.ID)
+		case visited:
+			return nil
+		}
+
+		state[i] = visiting
+		path = append(path, test.ID)
+		for _, dependency := range test.DependsOn {
+			if err := visit(ids[dependency]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range healthChecks.Tests {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependencyIndexes returns, for each healthcheck, the indexes of the
+// healthchecks it depends on. Dependencies that are not part of this run,
+// for instance because they were filtered out by tag, are ignored.
+func (healthChecks *Format) dependencyIndexes() [][]int {
+	ids := make(map[string]int)
+	for i, test := range healthChecks.Tests {
+		if test.ID != "" {
+			ids[test.ID] = i
+		}
+	}
+
+	dependencies := make([][]int, len(healthChecks.Tests))
+	for i, test := range healthChecks.Tests {
+		for _, dependency := range test.DependsOn {
+			if j, ok := ids[dependency]; ok {
+				dependencies[i] = append(dependencies[i], j)
+			}
+		}
+	}
+	return dependencies
+}
+
+// dependents returns, for each healthcheck, the indexes of the healthchecks
+// that depend on it
+func dependents(dependencies [][]int) [][]int {
+	dependents := make([][]int, len(dependencies))
+	for i, list := range dependencies {
+		for _, j := range list {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	return dependents
+}
+
+// failed is true when a healthcheck did not run, or ran and did not pass
+func (healthCheck SQLHealthCheck) failed() bool {
+	return !healthCheck.Passed || !healthCheck.Equal || healthCheck.State != ""
+}
@@ -1,34 +1,33 @@
 package healthcheck
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/cfpb/rhobot/internal/report"
 	"gopkg.in/yaml.v2"
 )
 
 // ReadHealthCheckYAMLFromFile loads healthcheck data from a YAML file
 func ReadHealthCheckYAMLFromFile(path string) (format Format, err error) {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return
-	}
+	return ReadHealthCheckYAMLFromFileWithVars(path, nil)
+}
 
-	err = yaml.Unmarshal(data, &format)
+// ReadHealthCheckYAMLFromFileWithVars loads healthcheck data from a YAML file
+// and the files it includes, resolving variables with vars overriding the
+// ones defined in the files
+func ReadHealthCheckYAMLFromFileWithVars(path string, vars map[string]string) (format Format, err error) {
+	format, err = newLoader().load(path, vars)
 	if err != nil {
 		return
 	}
 
-	valid := format.ValidateHealthChecks()
-	if !valid {
-		err = errors.New("Reading Healthcheck file failed")
-		return
-	}
-
+	err = format.validate()
 	return
 }
 
@@ -56,6 +55,33 @@ func (healthChecks *Format) RejectBadHealthChecks() {
 	healthChecks.Tests = GoodTests
 }
 
+// FilterTags keeps the healthchecks with any of tags, or all of them when tags
+// is empty, and drops the healthchecks with any of skipTags
+func (healthChecks *Format) FilterTags(tags []string, skipTags []string) {
+
+	var selected []SQLHealthCheck
+
+	for _, test := range healthChecks.Tests {
+		if (len(tags) == 0 || test.HasAnyTag(tags)) && !test.HasAnyTag(skipTags) {
+			selected = append(selected, test)
+		}
+	}
+
+	healthChecks.Tests = selected
+}
+
+// HasAnyTag is true when the healthcheck is tagged with one of tags
+func (healthCheck SQLHealthCheck) HasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		for _, own := range healthCheck.Tags {
+			if own == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // RunHealthChecks executes all health checks in the specified file
 func (healthChecks *Format) RunHealthChecks(cxn *sql.DB) {
 	for i := 0; i < len(healthChecks.Tests); i++ {
@@ -63,110 +89,373 @@ func (healthChecks *Format) RunHealthChecks(cxn *sql.DB) {
 	}
 }
 
-// PreformHealthChecks runs and evaluates healthChecks one at a time
+// OverrideTimeout replaces the suite and every healthcheck timeout
+func (healthChecks *Format) OverrideTimeout(timeout time.Duration) {
+	healthChecks.Timeout = timeout
+	for i := range healthChecks.Tests {
+		healthChecks.Tests[i].Timeout = timeout
+	}
+}
+
+// withDefaults fills in the suite's timeout, retry policy and session
+// for a healthcheck that does not set its own
+func (healthChecks *Format) withDefaults(test SQLHealthCheck) SQLHealthCheck {
+	if test.Timeout == 0 {
+		test.Timeout = healthChecks.Timeout
+	}
+	if test.Retries == nil {
+		retries := healthChecks.Retries
+		test.Retries = &retries
+	}
+	if test.RetryDelay == 0 {
+		test.RetryDelay = healthChecks.RetryDelay
+	}
+	if test.ReadOnly == nil {
+		test.ReadOnly = healthChecks.ReadOnly
+	}
+	if test.Session == (Session{}) {
+		test.Session = healthChecks.Session
+	}
+	return test
+}
+
+// PreformHealthChecks runs and evaluates healthChecks, Concurrency at a time
 func (healthChecks *Format) PreformHealthChecks(cxn *sql.DB) (results []SQLHealthCheck, errors []HCError) {
-	for i, test := range healthChecks.Tests {
-		if cxn != nil {
-			test.RunHealthCheck(cxn)
+	return healthChecks.PreformHealthChecksContext(context.Background(), cxn)
+}
+
+// PreformHealthChecksContext runs and evaluates healthChecks, Concurrency at a time.
+// Healthchecks start as soon as the ones they depend on are done, and are SKIPPED,
+// with an HCError giving the reason, when one of those failed. A failed FATAL healthcheck cancels the ones still running, results keep file order.
+// A schema healthcheck has a result for every drift from its contract.
+func (healthChecks *Format) PreformHealthChecksContext(ctx context.Context, cxn *sql.DB) (results []SQLHealthCheck, errors []HCError) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := healthChecks.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	//unfinished healthchecks are reported as they were defined
+	results = make([]SQLHealthCheck, len(healthChecks.Tests))
+	copy(results, healthChecks.Tests)
+	hcErrors := make([][]HCError, len(healthChecks.Tests))
+
+	dependencies := healthChecks.dependencyIndexes()
+	dependents := dependents(dependencies)
+	waiting := make([]int, len(dependencies))
+	reasons := make([]string, len(dependencies))
+	var ready []int
+	for i := range dependencies {
+		if waiting[i] = len(dependencies[i]); waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	// settle queues the dependents a finished healthcheck was the last to wait for,
+	// skipping them when one of their dependencies did not pass
+	settled := 0
+	var settle func(i int)
+	settle = func(i int) {
+		settled++
+		if ctx.Err() != nil {
+			return
+		}
+		for _, d := range dependents[i] {
+			if results[i].failed() && reasons[d] == "" {
+				reasons[d] = fmt.Sprintf("depends on %q which did not pass", results[i].Title)
+			}
+			if waiting[d] == 0 {
+				continue
+			}
+			if waiting[d]--; waiting[d] > 0 {
+				continue
+			}
+			if reasons[d] == "" {
+				ready = append(ready, d)
+				continue
+			}
+			hcErrors[d] = []HCError{results[d].skip(reasons[d])}
+			settle(d)
+		}
+	}
+
+	finished := make(chan int)
+	run := func(i int) {
+		defer func() { finished <- i }()
+
+		test := healthChecks.withDefaults(healthChecks.Tests[i])
+		var historyErr error
+		if test.Baseline != nil {
+			historyErr = test.loadBaseline(ctx, healthChecks.History)
+		}
+		if test.Anomaly != nil {
+			historyErr = test.loadAnomaly(ctx, healthChecks.History)
+		}
+		if historyErr != nil {
+			log.Errorf("healthcheck %q: %v", test.Title, historyErr)
+			test.Actual = historyErr.Error()
+		} else if cxn != nil {
+			test.RunHealthCheckWithRetries(ctx, cxn)
+			if !test.Passed && test.State != StateTimeout && ctx.Err() != nil {
+				log.Infof("healthcheck %q was cancelled", test.Title)
+				return
+			}
 		}
-		results = append(results, test)
-		hcErr := test.EvaluateHealthCheck()
 
-		if hcErr.Err != "" {
-			errors = append(errors, hcErr)
+		results[i] = test
+		for _, element := range test.elements() {
+			hcErr := element.EvaluateHealthCheck()
+			hcErrors[i] = append(hcErrors[i], hcErr)
+			if hcErr.Exit {
+				cancel()
+			}
 		}
+	}
 
-		if hcErr.Exit {
-			//add unfinished healthchecks
-			for _, unfinished := range healthChecks.Tests[i+1:] {
-				results = append(results, unfinished)
+	running := 0
+	for settled < len(results) {
+		for running < workers && len(ready) > 0 && ctx.Err() == nil {
+			go run(ready[0])
+			ready = ready[1:]
+			running++
+		}
+		if running == 0 {
+			if ctx.Err() != nil {
+				break
 			}
-			break
+			// a cycle, rejected when loading, run the rest in file order
+			for i := range waiting {
+				if waiting[i] > 0 {
+					waiting[i] = 0
+					ready = append(ready, i)
+				}
+			}
+			continue
 		}
+		settle(<-finished)
+		running--
+	}
 
+	checks := results
+	results = nil
+	for i, check := range checks {
+		results = append(results, check.elements()...)
+		for _, hcErr := range hcErrors[i] {
+			if hcErr.Err != "" {
+				errors = append(errors, hcErr)
+			}
+		}
 	}
 	return
 }
 
+// skip marks a healthcheck SKIPPED, returning the HCError that says why it did not run
+func (healthCheck *SQLHealthCheck) skip(reason string) HCError {
+	healthCheck.State = StateSkipped
+	healthCheck.Actual = reason
+	log.Infof("healthcheck %q skipped, %s", healthCheck.Title, reason)
+	severity := strings.ToUpper(healthCheck.Severity)
+	return HCError{Err: fmt.Sprintf("%s - healthcheck skipped, %s", severity, reason),
+		State: StateSkipped, Severity: severity}
+}
+
 // ValidateHealthCheck makes sure a helathcheck has all the fields populated
 func (healthCheck SQLHealthCheck) ValidateHealthCheck() bool {
-
-	if len(healthCheck.Expected) == 0 {
-		return false
+	problems := healthCheck.Problems()
+	for _, problem := range problems {
+		log.Errorf("healthcheck %q: %s %s", healthCheck.Title, problem.Field, problem.Message)
 	}
+	return len(problems) == 0
+}
 
-	if len(healthCheck.Query) == 0 {
-		return false
+// RunHealthCheck runs through a single healthcheck and saves the result
+func (healthCheck *SQLHealthCheck) RunHealthCheck(cxn *sql.DB) {
+	healthCheck.RunHealthCheckContext(context.Background(), cxn)
+}
+
+// RunHealthCheckContext runs through a single healthcheck until ctx is done
+// or its Timeout has passed
+func (healthCheck *SQLHealthCheck) RunHealthCheckContext(ctx context.Context, cxn *sql.DB) {
+	if healthCheck.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, healthCheck.Timeout)
+		defer cancel()
+	}
+	switch healthCheck.Kind {
+	case KindFreshness:
+		healthCheck.freshSince(time.Now())
+	case KindSchema:
+		healthCheck.Query, healthCheck.Args = healthCheck.contractQuery(cxn)
 	}
 
-	if len(healthCheck.Title) == 0 {
-		return false
+	rows, end, err := healthCheck.query(ctx, cxn)
+	if err != nil {
+		healthCheck.queryFailed(ctx, err)
+		return
 	}
+	defer end()
+	defer rows.Close()
 
-	if len(healthCheck.Severity) == 0 {
-		return false
+	result, err := readResultSet(rows)
+	if err != nil {
+		healthCheck.queryFailed(ctx, err)
+		return
 	}
 
-	return true
+	healthCheck.Passed = true
+	if healthCheck.Kind == KindSchema {
+		healthCheck.checkContract(result)
+		return
+	}
+	healthCheck.evaluateResult(result)
 }
 
-// RunHealthCheck runs through a single healthcheck and saves the result
-func (healthCheck *SQLHealthCheck) RunHealthCheck(cxn *sql.DB) {
-	answer := ""
+// queryFailed records a query error, or a timeout when the deadline was hit
+func (healthCheck *SQLHealthCheck) queryFailed(ctx context.Context, err error) {
+	healthCheck.Passed = false
+	if ctx.Err() == context.DeadlineExceeded {
+		healthCheck.State = StateTimeout
+		healthCheck.Actual = "query timed out"
+		if healthCheck.Timeout > 0 {
+			// without a timeout of its own the deadline was the caller's
+			healthCheck.Actual = fmt.Sprintf("query timed out after %v", healthCheck.Timeout)
+		}
+		log.Errorf("healthcheck %q: %s", healthCheck.Title, healthCheck.Actual)
+		return
+	}
+	log.Error(err)
+	healthCheck.Actual = err.Error()
+}
 
-	rows, err := cxn.Query(healthCheck.Query)
+// evaluateResult compares a query result with the expected values
+func (healthCheck *SQLHealthCheck) evaluateResult(result resultSet) {
+	column, err := result.columnIndex(healthCheck.Column)
 	if err != nil {
 		log.Error(err)
-		healthCheck.Passed = false
-		healthCheck.Actual = err.Error()
-	} else {
-		defer rows.Close()
-		rows.Next()
-		rows.Scan(&answer)
-
-		compResult := false
-		switch strings.ToLower(healthCheck.Operation) {
-		case "eq":
-			compResult = healthCheck.Expected == answer
-		case "ne":
-			compResult = healthCheck.Expected != answer
-		case "lt":
-			compResult = healthCheck.Expected < answer
-		case "le":
-			compResult = healthCheck.Expected <= answer
-		case "gt":
-			compResult = healthCheck.Expected > answer
-		case "ge":
-			compResult = healthCheck.Expected >= answer
-		default:
-			log.Info("opperation not specified, checking if equals")
-			compResult = healthCheck.Expected == answer
+	}
+
+	dataType := strings.ToLower(healthCheck.Type)
+	if dataType == "" {
+		dataType = "text"
+		if column < len(result.types) {
+			dataType = result.types[column]
 		}
+	}
 
-		healthCheck.Passed = true
-		healthCheck.Actual = answer
-		healthCheck.Equal = compResult
+	answer := result.value(0, column)
+	compResult := err == nil
+	if compResult && healthCheck.Anomaly != nil {
+		compResult, err = healthCheck.checkAnomaly(answer)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if compResult && healthCheck.Baseline != nil && healthCheck.Baseline.bounded() {
+		compResult, err = healthCheck.checkBaseline(answer)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if compResult {
+		compResult, err = healthCheck.checkOperation(result, column, dataType)
+		if err != nil {
+			log.Error(err)
+		}
 	}
 
+	if compResult && healthCheck.assertsResultSet() {
+		compResult, err = healthCheck.compareResultSet(result)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+
+	if healthCheck.assertsResultSet() {
+		healthCheck.Actual = result.String()
+	} else {
+		healthCheck.Actual = answer
+	}
+	healthCheck.Equal = compResult
+	healthCheck.Type = dataType
 }
 
-// EvaluateHCErrors given a slice of HCErrors, determine if error or early exit
-func EvaluateHCErrors(hcerrors []HCError) (int, int, bool) {
-	numErrors := 0
-	numWarnings := 0
-	fatal := false
+// EvaluateHCErrors given a slice of HCErrors, determine if error or early exit,
+// counting each by the severity of its healthcheck. Skipped healthchecks are not counted.
+func EvaluateHCErrors(hcerrors []HCError) (numErrors int, numWarnings int, fatal bool) {
 	for _, hcerr := range hcerrors {
-		if strings.Contains(strings.ToUpper(hcerr.Err), "FATAL") {
+		if hcerr.State == StateSkipped {
+			continue
+		}
+		switch hcerr.Severity {
+		case "FATAL":
 			fatal = true
+		case "ERROR":
+			numErrors++
+		case "WARN":
+			numWarnings++
 		}
-		if strings.Contains(strings.ToUpper(hcerr.Err), "ERROR") {
-			numErrors = numErrors + 1
+	}
+	return
+}
+
+// EvaluateReportSet counts the failed healthchecks of a report set by severity,
+// as EvaluateHCErrors counts errors. Skipped healthchecks are not counted.
+func EvaluateReportSet(rs report.Set) (numErrors int, numWarnings int, fatal bool) {
+	for _, element := range rs.Elements {
+		if element.GetValue("State") == StateSkipped ||
+			element.GetValue("Passed") == "SUCCESS" && element.GetValue("Equal") == "TRUE" {
+			continue
+		}
+		switch strings.ToUpper(element.GetValue("Severity")) {
+		case "FATAL":
+			fatal = true
+		case "ERROR":
+			numErrors++
+		case "WARN":
+			numWarnings++
 		}
-		if strings.Contains(strings.ToUpper(hcerr.Err), "WARN") {
-			numWarnings = numWarnings + 1
+	}
+	return
+}
+
+// Exit codes of a healthcheck run, a worse outcome has a higher code
+const (
+	ExitPass   = 0
+	ExitWarn   = 1
+	ExitError  = 2
+	ExitFatal  = 3
+	ExitConfig = 4
+)
+
+// FailOnLevels are the severities a run can be made to fail at
+var FailOnLevels = []string{"warn", "error", "fatal"}
+
+// ParseFailOn returns the lowest exit code that fails a run at the given severity
+func ParseFailOn(level string) (int, error) {
+	for i, failOn := range FailOnLevels {
+		if strings.ToLower(level) == failOn {
+			return ExitWarn + i, nil
 		}
 	}
-	return numErrors, numWarnings, fatal
+	return ExitConfig, fmt.Errorf("unknown fail on level %q, use one of %s", level, strings.Join(FailOnLevels, ", "))
+}
+
+// ExitCode returns the exit code of a run from its evaluated errors. Outcomes
+// below the threshold given by ParseFailOn do not fail the run.
+func ExitCode(numErrors int, numWarnings int, fatal bool, threshold int) int {
+	code := ExitPass
+	switch {
+	case fatal:
+		code = ExitFatal
+	case numErrors > 0:
+		code = ExitError
+	case numWarnings > 0:
+		code = ExitWarn
+	}
+	if code < threshold {
+		return ExitPass
+	}
+	return code
 }
 
 // EvaluateHealthCheck runs through a single healthcheck and acts on the result
@@ -178,6 +467,10 @@ func (healthCheck *SQLHealthCheck) EvaluateHealthCheck() (err HCError) {
 		earlyExit := false
 		errorMsg := fmt.Sprintf("%s - healthcheck failed \n%s",
 			severity, string(prettyHealthCheck))
+		if healthCheck.State == StateTimeout {
+			errorMsg = fmt.Sprintf("%s - healthcheck timed out \n%s",
+				severity, string(prettyHealthCheck))
+		}
 
 		switch strings.ToUpper(healthCheck.Severity) {
 		case "FATAL":
@@ -194,7 +487,7 @@ func (healthCheck *SQLHealthCheck) EvaluateHealthCheck() (err HCError) {
 		default:
 			log.Errorf("Breaking Away Early %s\n%s ", severity, errorMsg)
 		}
-		err = HCError{errorMsg, earlyExit}
+		err = HCError{Err: errorMsg, Exit: earlyExit, State: healthCheck.State, Severity: severity}
 	} else {
 		happyMsg := fmt.Sprintf("%s - healthcheck passed \n%s",
 			severity, string(prettyHealthCheck))
@@ -206,7 +499,7 @@ func (healthCheck *SQLHealthCheck) EvaluateHealthCheck() (err HCError) {
 // Implementation of report.Element
 
 // HealthCheckReportHeaders headers used for GetHeaders
-var HealthCheckReportHeaders = []string{"Title", "Query", "Passed", "Expected", "Actual", "Equal", "Severity", "Operation"}
+var HealthCheckReportHeaders = []string{"Title", "Query", "Passed", "Expected", "Actual", "Equal", "Severity", "Operation", "Type", "State", "Suite", "Source", "Tags", "Attempts", "Duration", "Target"}
 
 // GetHeaders Implementation for report.Element
 func (healthCheck SQLHealthCheck) GetHeaders() []string {
@@ -237,7 +530,32 @@ func (healthCheck SQLHealthCheck) GetValue(key string) string {
 	case HealthCheckReportHeaders[6]:
 		return strings.ToUpper(healthCheck.Severity)
 	case HealthCheckReportHeaders[7]:
+		if healthCheck.Operation == "" && healthCheck.Anomaly != nil {
+			return "ANOMALY"
+		}
+		if healthCheck.Operation == "" && healthCheck.Baseline != nil {
+			return "BASELINE"
+		}
 		return strings.ToUpper(healthCheck.Operation)
+	case HealthCheckReportHeaders[8]:
+		return healthCheck.Type
+	case HealthCheckReportHeaders[9]:
+		return healthCheck.State
+	case HealthCheckReportHeaders[10]:
+		return healthCheck.Suite
+	case HealthCheckReportHeaders[11]:
+		return healthCheck.Source
+	case HealthCheckReportHeaders[12]:
+		return strings.Join(healthCheck.Tags, ",")
+	case HealthCheckReportHeaders[13]:
+		return strconv.Itoa(len(healthCheck.Attempts))
+	case HealthCheckReportHeaders[14]:
+		if healthCheck.Duration == 0 {
+			return ""
+		}
+		return healthCheck.Duration.String()
+	case HealthCheckReportHeaders[15]:
+		return healthCheck.Target
 	}
 	return ""
 }
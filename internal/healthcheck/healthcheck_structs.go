@@ -1,28 +1,95 @@
 package healthcheck
 
+import (
+	"time"
+
+	"github.com/cfpb/rhobot/internal/jsonschema"
+)
+
+const (
+	// StateTimeout marks a healthcheck whose query ran longer than its timeout
+	StateTimeout = "TIMEOUT"
+	// StateSkipped marks a healthcheck not run because a dependency failed
+	StateSkipped = "SKIPPED"
+)
+
 // SQLHealthCheck is a data type for storing the definition
 // and results of a SQL based health check
 type SQLHealthCheck struct {
-	Expected  string `yaml:"expected"`
-	Query     string `yaml:"query"`
-	Title     string `yaml:"title"`
-	Severity  string `yaml:"severity"`
-	Operation string `yaml:"operation,omitempty"`
-	Passed    bool
-	Actual    string
-	Equal     bool
+	ID               string            `yaml:"id,omitempty"`
+	DependsOn        []string          `yaml:"depends_on,omitempty"`
+	Expected         string            `yaml:"expected"`
+	Query            string            `yaml:"query"`
+	Kind             string            `yaml:"kind,omitempty"`
+	Table            string            `yaml:"table,omitempty"`
+	Columns          []string          `yaml:"columns,omitempty"`
+	References       *Reference        `yaml:"references,omitempty"`
+	Where            string            `yaml:"where,omitempty"`
+	MaxAge           string            `yaml:"max_age,omitempty"`
+	Schema           *Contract         `yaml:"schema,omitempty"`
+	Title            string            `yaml:"title" jsonschema:"required"`
+	Severity         string            `yaml:"severity" jsonschema:"required"`
+	Operation        string            `yaml:"operation,omitempty"`
+	Type             string            `yaml:"type,omitempty"`
+	Values           []string          `yaml:"values,omitempty"`
+	Min              string            `yaml:"min,omitempty"`
+	Max              string            `yaml:"max,omitempty"`
+	Tolerance        string            `yaml:"tolerance,omitempty"`
+	Baseline         *Baseline         `yaml:"baseline,omitempty"`
+	Anomaly          *Anomaly          `yaml:"anomaly,omitempty"`
+	Column           string            `yaml:"column,omitempty"`
+	ExpectNoRows     bool              `yaml:"expect_no_rows,omitempty"`
+	ExpectedRowCount *int              `yaml:"expected_row_count,omitempty"`
+	ExpectedRows     [][]string        `yaml:"expected_rows,omitempty"`
+	ExpectedColumns  map[string]string `yaml:"expected_columns,omitempty"`
+	Timeout          time.Duration     `yaml:"timeout,omitempty"`
+	Args             []interface{}     `yaml:"-"`
+	Tags             []string          `yaml:"tags,omitempty"`
+	Retries          *int              `yaml:"retries,omitempty"`
+	RetryDelay       time.Duration     `yaml:"retry_delay,omitempty"`
+	Passed           bool              `jsonschema:"-"`
+	Actual           string            `jsonschema:"-"`
+	Equal            bool              `jsonschema:"-"`
+	State            string            `yaml:"state,omitempty" jsonschema:"-"`
+	Attempts         []Attempt         `yaml:"attempts,omitempty" jsonschema:"-"`
+	Duration         time.Duration     `yaml:"duration,omitempty" jsonschema:"-"`
+	Drifts           []Drift           `yaml:"-"`
+	ReadOnly         *bool             `yaml:"-"`
+	Session          Session           `yaml:"-"`
+	Suite            string            `yaml:"-"`
+	Target           string            `yaml:"-"`
+	Source           string            `yaml:"-"`
 }
 
 // Format is for unmarshiling a healthcheck file
 // and contains control information for a set of SQLHealthChecks
 type Format struct {
-	Name         string           `yaml:"name"`
-	Distribution []string         `yaml:"distribution"`
-	Tests        []SQLHealthCheck `yaml:"tests"`
+	Name            string            `yaml:"name"`
+	Distribution    []string          `yaml:"distribution"`
+	Concurrency     int               `yaml:"concurrency,omitempty"`
+	Timeout         time.Duration     `yaml:"timeout,omitempty"`
+	Retries         int               `yaml:"retries,omitempty"`
+	RetryDelay      time.Duration     `yaml:"retry_delay,omitempty"`
+	Vars            map[string]string `yaml:"vars,omitempty"`
+	Include         []string          `yaml:"include,omitempty"`
+	ReadOnly        *bool             `yaml:"read_only,omitempty"`
+	Session         Session           `yaml:"session,omitempty"`
+	Targets         []Target          `yaml:"targets,omitempty"`
+	ParallelTargets bool              `yaml:"parallel_targets,omitempty"`
+	History         HistorySource     `yaml:"-"`
+	Tests           []SQLHealthCheck  `yaml:"tests"`
+}
+
+// FormatSchema returns the JSON Schema of a healthcheck file
+func FormatSchema() jsonschema.Schema {
+	return jsonschema.Generate(Format{}, "rhobot healthcheck file")
 }
 
-// HCError is a error helper for knowing to exit early on a failed healthcheck
+// HCError is a error helper for knowing to exit early on a failed healthcheck,
+// Severity being the upper cased severity of that healthcheck
 type HCError struct {
-	Err  string
-	Exit bool
+	Err      string
+	Exit     bool
+	State    string
+	Severity string
 }
@@ -0,0 +1,255 @@
+package healthcheck
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Kinds of healthchecks rhobot writes the query for
+const (
+	KindRowCount       = "row_count"
+	KindNotNull        = "not_null"
+	KindUnique         = "unique"
+	KindFreshness      = "freshness"
+	KindForeignKey     = "foreign_key"
+	KindAcceptedValues = "accepted_values"
+	KindSchema         = "schema"
+)
+
+// Kinds are the built-in healthchecks, a healthcheck without a kind has its own query
+var Kinds = []string{KindRowCount, KindNotNull, KindUnique, KindFreshness, KindForeignKey, KindAcceptedValues, KindSchema}
+
+// Reference is the table and columns a foreign_key healthcheck looks rows up in
+type Reference struct {
+	Table   string   `yaml:"table" jsonschema:"required"`
+	Columns []string `yaml:"columns" jsonschema:"required"`
+}
+
+// ValidKind reports whether kind is empty or one of Kinds
+func ValidKind(kind string) bool {
+	if kind == "" {
+		return true
+	}
+	for _, known := range Kinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validateKind checks the fields a kind needs are present and parse,
+// returning the field at fault
+func (healthCheck SQLHealthCheck) validateKind() (string, error) {
+	kind := healthCheck.Kind
+	if !ValidKind(kind) {
+		return "kind", fmt.Errorf("unknown kind %q, use one of %v", kind, Kinds)
+	}
+	if healthCheck.Table == "" {
+		return "table", fmt.Errorf("kind %s needs a table", kind)
+	}
+	if healthCheck.Schema != nil && kind != KindSchema {
+		return "schema", fmt.Errorf("can only be used with kind %s", KindSchema)
+	}
+
+	switch kind {
+	case KindNotNull, KindUnique, KindForeignKey:
+		if len(healthCheck.Columns) == 0 {
+			return "columns", fmt.Errorf("kind %s needs at least one column", kind)
+		}
+	case KindFreshness, KindAcceptedValues:
+		if len(healthCheck.Columns) != 1 {
+			return "columns", fmt.Errorf("kind %s needs exactly one column", kind)
+		}
+	}
+
+	switch kind {
+	case KindForeignKey:
+		references := healthCheck.References
+		if references == nil || references.Table == "" {
+			return "references", fmt.Errorf("kind %s needs the table it references", kind)
+		}
+		if len(references.Columns) != len(healthCheck.Columns) {
+			return "references", fmt.Errorf("kind %s needs as many referenced columns as columns", kind)
+		}
+	case KindAcceptedValues:
+		if len(healthCheck.Values) == 0 {
+			return "values", fmt.Errorf("kind %s needs a list of values", kind)
+		}
+	case KindFreshness:
+		if _, err := healthCheck.maxAge(); err != nil {
+			return "max_age", err
+		}
+	case KindSchema:
+		if healthCheck.Schema == nil {
+			return "schema", fmt.Errorf("kind %s needs the schema the table should have", kind)
+		}
+		if err := healthCheck.Schema.validate(); err != nil {
+			return "schema", err
+		}
+	}
+	return "", nil
+}
+
+// compileKind expands variables in the kind's fields and writes its query,
+// defaulting the comparison to what the kind checks. Variables in where are
+// left for bindVars to bind as parameters. A kind that does not validate is
+// left without a query for Problems to report, and schema gets the query of
+// its database when it runs.
+func (healthCheck *SQLHealthCheck) compileKind(vars map[string]string) (err error) {
+	if healthCheck.Query != "" {
+		return fmt.Errorf("query can not be used together with kind %s", healthCheck.Kind)
+	}
+	if healthCheck.Kind == KindFreshness && (healthCheck.Expected != "" || healthCheck.Operation != "") {
+		return fmt.Errorf("kind %s works out the expected value from max_age", healthCheck.Kind)
+	}
+	if healthCheck.Table, err = expandVars(healthCheck.Table, vars); err != nil {
+		return
+	}
+	for i := range healthCheck.Columns {
+		if healthCheck.Columns[i], err = expandVars(healthCheck.Columns[i], vars); err != nil {
+			return
+		}
+	}
+	if references := healthCheck.References; references != nil {
+		if references.Table, err = expandVars(references.Table, vars); err != nil {
+			return
+		}
+		for i := range references.Columns {
+			if references.Columns[i], err = expandVars(references.Columns[i], vars); err != nil {
+				return
+			}
+		}
+	}
+	if healthCheck.MaxAge, err = expandVars(healthCheck.MaxAge, vars); err != nil {
+		return
+	}
+	if _, invalid := healthCheck.validateKind(); invalid != nil || healthCheck.Kind == KindSchema {
+		return nil
+	}
+
+	healthCheck.Query, healthCheck.Args = healthCheck.kindQuery()
+	healthCheck.kindDefaults()
+	return nil
+}
+
+// kindQuery writes the query of a kind, which counts the offending rows
+// except for freshness, which selects the newest timestamp. Accepted values
+// are bound as numbered parameters, appended to the healthcheck's args.
+func (healthCheck SQLHealthCheck) kindQuery() (string, []interface{}) {
+	args := healthCheck.Args
+	table := quoteIdentifier(healthCheck.Table)
+	columns := make([]string, len(healthCheck.Columns))
+	for i, column := range healthCheck.Columns {
+		columns[i] = quoteIdentifier(column)
+	}
+
+	var conditions []string
+	where := func() string {
+		if healthCheck.Where != "" {
+			conditions = append(conditions, "("+healthCheck.Where+")")
+		}
+		if len(conditions) == 0 {
+			return ""
+		}
+		return " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	switch healthCheck.Kind {
+	case KindNotNull:
+		var nulls []string
+		for _, column := range columns {
+			nulls = append(nulls, column+" IS NULL")
+		}
+		conditions = append(conditions, "("+strings.Join(nulls, " OR ")+")")
+		return "SELECT count(*) FROM " + table + where(), args
+	case KindUnique:
+		for _, column := range columns {
+			conditions = append(conditions, column+" IS NOT NULL")
+		}
+		list := strings.Join(columns, ", ")
+		return "SELECT count(*) FROM (SELECT " + list + " FROM " + table + where() +
+			" GROUP BY " + list + " HAVING count(*) > 1) AS duplicates", args
+	case KindAcceptedValues:
+		placeholders := make([]string, len(healthCheck.Values))
+		for i, value := range healthCheck.Values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, columns[0]+" IS NOT NULL",
+			columns[0]+" NOT IN ("+strings.Join(placeholders, ", ")+")")
+		return "SELECT count(*) FROM " + table + where(), args
+	case KindForeignKey:
+		var matches []string
+		for i, column := range columns {
+			conditions = append(conditions, "child."+column+" IS NOT NULL")
+			matches = append(matches, "parent."+quoteIdentifier(healthCheck.References.Columns[i])+" = child."+column)
+		}
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM "+quoteIdentifier(healthCheck.References.Table)+
+			" AS parent WHERE "+strings.Join(matches, " AND ")+")")
+		return "SELECT count(*) FROM " + table + " AS child" + where(), args
+	case KindFreshness:
+		return "SELECT max(" + columns[0] + ") FROM " + table + where(), args
+	default:
+		return "SELECT count(*) FROM " + table + where(), args
+	}
+}
+
+// kindDefaults sets the comparison a kind makes unless the healthcheck set its own:
+// at least one row, or between min and max, for row_count, no offending rows for the other counts and
+// a newest timestamp no older than max_age for freshness
+func (healthCheck *SQLHealthCheck) kindDefaults() {
+	if healthCheck.Kind == KindFreshness {
+		if healthCheck.Type == "" {
+			healthCheck.Type = "timestamp"
+		}
+		healthCheck.Operation = "le"
+		return
+	}
+
+	if healthCheck.Type == "" {
+		healthCheck.Type = "integer"
+	}
+	if healthCheck.Baseline != nil || healthCheck.Anomaly != nil ||
+		healthCheck.Expected != "" || healthCheck.Operation != "" {
+		return
+	}
+	if healthCheck.Kind == KindRowCount {
+		if healthCheck.Min != "" || healthCheck.Max != "" {
+			healthCheck.Operation = "between"
+		} else {
+			healthCheck.Operation, healthCheck.Expected = "lt", "0"
+		}
+		return
+	}
+	healthCheck.Expected = "0"
+}
+
+// maxAge reads the max_age of a freshness healthcheck, an interval such as "1 day" or "36h"
+func (healthCheck SQLHealthCheck) maxAge() (time.Duration, error) {
+	if healthCheck.MaxAge == "" {
+		return 0, fmt.Errorf("kind %s needs a max_age", KindFreshness)
+	}
+	seconds, err := parseInterval(healthCheck.MaxAge)
+	if err != nil {
+		return 0, err
+	}
+	if seconds.Sign() <= 0 {
+		return 0, fmt.Errorf("max_age %q must be positive", healthCheck.MaxAge)
+	}
+	nanoseconds := new(big.Rat).Mul(seconds, big.NewRat(int64(time.Second), 1))
+	age, _ := nanoseconds.Float64()
+	return time.Duration(age), nil
+}
+
+// freshSince sets Expected to the oldest timestamp a freshness healthcheck accepts.
+// Timestamps without a time zone are compared as UTC.
+func (healthCheck *SQLHealthCheck) freshSince(now time.Time) {
+	age, err := healthCheck.maxAge()
+	if err != nil {
+		return
+	}
+	healthCheck.Expected = now.Add(-age).UTC().Format(time.RFC3339Nano)
+}
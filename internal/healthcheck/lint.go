@@ -0,0 +1,255 @@
+package healthcheck
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cfpb/rhobot/internal/jsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// prepareTimeout limits how long linting waits for a query to be prepared
+const prepareTimeout = 30 * time.Second
+
+// yamlErrorLine finds the line number in a yaml error message
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// linter checks healthcheck files, keeping the parsed files to find lines in
+type linter struct {
+	vars     map[string]string
+	cxn      *sql.DB
+	nodes    map[string]*yaml.Node
+	problems []Problem
+	reported map[Problem]bool
+}
+
+// Lint checks healthcheck files, or every healthcheck file in a directory, and
+// returns every problem found with the file and line it is on. When cxn is not
+// nil every query is also prepared on it to catch SQL errors.
+func Lint(paths []string, vars map[string]string, cxn *sql.DB) []Problem {
+	l := linter{
+		vars:     vars,
+		cxn:      cxn,
+		nodes:    make(map[string]*yaml.Node),
+		reported: make(map[Problem]bool),
+	}
+
+	for _, path := range paths {
+		files, err := healthCheckFiles(path)
+		if err != nil {
+			l.report(Problem{File: path, Message: err.Error()})
+			continue
+		}
+		for _, file := range files {
+			l.lintFile(file)
+		}
+	}
+	return l.problems
+}
+
+// healthCheckFiles returns path, or the healthcheck files in it when it is a directory
+func healthCheckFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && hasHealthCheckExtension(entry.Name()) {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// report adds a problem, once
+func (l *linter) report(problem Problem) {
+	if !l.reported[problem] {
+		l.reported[problem] = true
+		l.problems = append(l.problems, problem)
+	}
+}
+
+// lintFile checks one healthcheck file and the files it includes
+func (l *linter) lintFile(path string) {
+	if l.parse(path) == nil {
+		return
+	}
+
+	format, err := newLoader().load(path, l.vars)
+	if err != nil {
+		l.report(Problem{File: path, Message: err.Error()})
+		return
+	}
+
+	titles := make(map[string]bool)
+	positions := make(map[string]int)
+	for _, test := range format.Tests {
+		node := l.testNode(test.Source, positions[test.Source])
+		positions[test.Source]++
+
+		for _, problem := range test.Problems() {
+			problem.File, problem.Title = test.Source, test.Title
+			problem.Line = fieldLine(node, problem.Field)
+			l.report(problem)
+		}
+
+		if test.Title != "" && titles[test.Title] {
+			l.report(Problem{File: test.Source, Line: fieldLine(node, "title"), Title: test.Title,
+				Field: "title", Message: "is used by more than one healthcheck"})
+		}
+		titles[test.Title] = true
+
+		if l.cxn != nil && test.Query != "" {
+			if err := l.prepare(test); err != nil {
+				l.report(Problem{File: test.Source, Line: fieldLine(node, "query"), Title: test.Title,
+					Field: "query", Message: "failed to prepare: " + err.Error()})
+			}
+		}
+	}
+
+	if err := format.CheckDependencies(); err != nil {
+		l.report(Problem{File: path, Field: "depends_on", Message: err.Error()})
+	}
+}
+
+// parse reads a file into yaml nodes, reporting syntax errors and unknown keys
+func (l *linter) parse(path string) *yaml.Node {
+	if node, ok := l.nodes[path]; ok {
+		return node
+	}
+	l.nodes[path] = nil
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		l.report(Problem{File: path, Message: err.Error()})
+		return nil
+	}
+
+	var node yaml.Node
+	if err = yaml.Unmarshal(data, &node); err != nil {
+		problem := Problem{File: path, Message: err.Error()}
+		if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			problem.Line, _ = strconv.Atoi(match[1])
+		}
+		l.report(problem)
+		return nil
+	}
+
+	for _, problem := range unknownKeys(&node, reflect.TypeOf(Format{})) {
+		problem.File = path
+		l.report(problem)
+	}
+	l.nodes[path] = &node
+	return &node
+}
+
+// testNode returns the yaml node of the i-th healthcheck in a file
+func (l *linter) testNode(path string, i int) *yaml.Node {
+	node := l.parse(path)
+	if node == nil || len(node.Content) == 0 {
+		return nil
+	}
+	tests := mappingValue(node.Content[0], "tests")
+	if tests == nil || tests.Kind != yaml.SequenceNode || i >= len(tests.Content) {
+		return nil
+	}
+	return tests.Content[i]
+}
+
+// prepare asks the database to parse a healthcheck query without running it
+func (l *linter) prepare(test SQLHealthCheck) error {
+	ctx, cancel := context.WithTimeout(context.Background(), prepareTimeout)
+	defer cancel()
+
+	stmt, err := l.cxn.PrepareContext(ctx, test.Query)
+	if err != nil {
+		return err
+	}
+	return stmt.Close()
+}
+
+// fieldLine returns the line of a key in a mapping node, or of the node itself
+func fieldLine(node *yaml.Node, field string) int {
+	if node == nil {
+		return 0
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == field {
+			return node.Content[i].Line
+		}
+	}
+	return node.Line
+}
+
+// mappingValue returns the value of a key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// unknownKeys reports mapping keys that do not match a yaml field of t
+func unknownKeys(node *yaml.Node, t reflect.Type) (problems []Problem) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			problems = append(problems, unknownKeys(child, t)...)
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for _, child := range node.Content {
+				problems = append(problems, unknownKeys(child, t.Elem())...)
+			}
+		}
+	case yaml.MappingNode:
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			field, ok := fields[key.Value]
+			if !ok {
+				problems = append(problems, Problem{Line: key.Line, Field: key.Value, Message: "unknown key"})
+				continue
+			}
+			problems = append(problems, unknownKeys(node.Content[i+1], field)...)
+		}
+	}
+	return
+}
+
+// yamlFields maps the yaml keys of a struct to their types
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for _, field := range jsonschema.Fields(t) {
+		fields[field.Name] = field.Type
+	}
+	return fields
+}
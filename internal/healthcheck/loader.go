@@ -0,0 +1,205 @@
+package healthcheck
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// healthCheckExtensions are the file extensions loaded from a directory
+var healthCheckExtensions = []string{".yml", ".yaml"}
+
+// loader reads healthcheck files and the files they include, once each
+type loader struct {
+	loaded map[string]bool
+}
+
+func newLoader() *loader {
+	return &loader{loaded: make(map[string]bool)}
+}
+
+// ReadHealthChecksFromPath loads a healthcheck file, or every healthcheck file
+// in a directory, into a single Format
+func ReadHealthChecksFromPath(path string, vars map[string]string) (format Format, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return ReadHealthCheckYAMLFromFileWithVars(path, vars)
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return
+	}
+
+	l := newLoader()
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || !hasHealthCheckExtension(file.Name()) {
+			continue
+		}
+		filePath := filepath.Join(path, file.Name())
+		if l.loaded[absPath(filePath)] {
+			continue
+		}
+
+		suite, loadErr := l.load(filePath, vars)
+		if loadErr != nil {
+			return format, loadErr
+		}
+		names = append(names, suite.Name)
+		format.merge(suite)
+	}
+	if len(names) == 0 {
+		return format, fmt.Errorf("no healthcheck files found in %s", path)
+	}
+	format.Name = strings.Join(names, ", ")
+
+	err = format.validate()
+	return
+}
+
+// load reads one healthcheck file and everything it includes
+func (l *loader) load(path string, vars map[string]string) (format Format, err error) {
+	l.loaded[absPath(path)] = true
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(data, &format)
+	if err != nil {
+		return format, fmt.Errorf("%s: %v", path, err)
+	}
+
+	err = format.ResolveVars(vars)
+	if err != nil {
+		return format, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for i := range format.Tests {
+		format.Tests[i] = format.withDefaults(format.Tests[i])
+		format.Tests[i].Source = path
+		format.Tests[i].Suite = format.Name
+	}
+
+	var included Format
+	for _, pattern := range format.Include {
+		pattern, err = expandVars(pattern, format.Vars)
+		if err != nil {
+			return format, fmt.Errorf("%s: include %v", path, err)
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, globErr := filepath.Glob(pattern)
+		if globErr != nil {
+			return format, fmt.Errorf("%s: include %v", path, globErr)
+		}
+		if len(matches) == 0 {
+			return format, fmt.Errorf("%s: include %q matched no files", path, pattern)
+		}
+
+		sort.Strings(matches)
+		for _, match := range matches {
+			if l.loaded[absPath(match)] {
+				continue
+			}
+			suite, loadErr := l.load(match, format.Vars)
+			if loadErr != nil {
+				return format, loadErr
+			}
+			included.merge(suite)
+		}
+	}
+
+	format.Tests = append(included.Tests, format.Tests...)
+	format.Distribution = append(format.Distribution, included.Distribution...)
+	return
+}
+
+// merge appends the healthchecks, distribution and targets of another suite.
+// The suite's own settings were applied to its healthchecks when it was loaded,
+// only its concurrency carries over, the largest one winning.
+func (healthChecks *Format) merge(suite Format) {
+	if suite.Concurrency > healthChecks.Concurrency {
+		healthChecks.Concurrency = suite.Concurrency
+	}
+	healthChecks.Tests = append(healthChecks.Tests, suite.Tests...)
+	healthChecks.Distribution = append(healthChecks.Distribution, suite.Distribution...)
+	healthChecks.Targets = append(healthChecks.Targets, suite.Targets...)
+	healthChecks.ParallelTargets = healthChecks.ParallelTargets || suite.ParallelTargets
+	if healthChecks.Vars == nil {
+		healthChecks.Vars = make(map[string]string)
+	}
+	for key, value := range suite.Vars {
+		if _, ok := healthChecks.Vars[key]; !ok {
+			healthChecks.Vars[key] = value
+		}
+	}
+}
+
+// validate checks every healthcheck, that titles are unique,
+// that dependencies are known and acyclic and that targets are complete
+func (healthChecks *Format) validate() error {
+	if err := healthChecks.CheckTitleCollisions(); err != nil {
+		return err
+	}
+	if err := healthChecks.CheckDependencies(); err != nil {
+		return err
+	}
+	if err := CheckTargets(healthChecks.Targets); err != nil {
+		return err
+	}
+	if !healthChecks.ValidateHealthChecks() {
+		return errors.New("Reading Healthcheck file failed")
+	}
+	return nil
+}
+
+// CheckTitleCollisions returns an error when two healthchecks have the same title,
+// which identifies a healthcheck in its history, dependencies and metrics
+func (healthChecks *Format) CheckTitleCollisions() error {
+	sources := make(map[string]string)
+	for _, test := range healthChecks.Tests {
+		source, ok := sources[test.Title]
+		if ok && source != test.Source {
+			return fmt.Errorf("healthcheck title %q is defined in both %s and %s",
+				test.Title, source, test.Source)
+		}
+		if ok {
+			return fmt.Errorf("healthcheck title %q is used more than once in %s", test.Title, source)
+		}
+		sources[test.Title] = test.Source
+	}
+	return nil
+}
+
+// hasHealthCheckExtension is true for file names ending in healthCheckExtensions
+func hasHealthCheckExtension(name string) bool {
+	for _, extension := range healthCheckExtensions {
+		if strings.HasSuffix(strings.ToLower(name), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath returns an absolute path, or path itself when it can not be resolved
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
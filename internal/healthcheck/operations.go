@@ -0,0 +1,186 @@
+package healthcheck
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Operations are the comparisons a healthcheck can make, no operation means eq
+var Operations = []string{
+	"eq", "ne", "lt", "le", "gt", "ge",
+	"regex", "in", "between", "is_null", "not_null", "contains", "within",
+}
+
+// ValidOperation reports whether operation is empty or one of Operations
+func ValidOperation(operation string) bool {
+	if operation == "" {
+		return true
+	}
+	for _, known := range Operations {
+		if strings.ToLower(operation) == known {
+			return true
+		}
+	}
+	return false
+}
+
+// usesExpected is false for operations that do not compare against Expected,
+// and for healthchecks whose kind works Expected out
+func (healthCheck SQLHealthCheck) usesExpected() bool {
+	if healthCheck.Baseline != nil || healthCheck.Anomaly != nil || healthCheck.Kind != "" {
+		return false
+	}
+	switch strings.ToLower(healthCheck.Operation) {
+	case "in", "between", "is_null", "not_null":
+		return false
+	}
+	return true
+}
+
+// validateOperation checks the fields an operation needs are present and parse,
+// returning the field at fault
+func (healthCheck SQLHealthCheck) validateOperation() (string, error) {
+	if !ValidOperation(healthCheck.Operation) {
+		return "operation", fmt.Errorf("unknown operation %q", healthCheck.Operation)
+	}
+
+	switch strings.ToLower(healthCheck.Operation) {
+	case "regex":
+		if _, err := regexp.Compile(healthCheck.Expected); err != nil {
+			return "expected", fmt.Errorf("invalid regex: %v", err)
+		}
+	case "in":
+		if len(healthCheck.Values) == 0 {
+			return "values", fmt.Errorf("operation in needs a list of values")
+		}
+		for _, value := range healthCheck.Values {
+			if err := healthCheck.parseTyped(value); err != nil {
+				return "values", err
+			}
+		}
+	case "between":
+		if healthCheck.Min == "" && healthCheck.Max == "" {
+			return "min", fmt.Errorf("operation between needs a min, a max or both")
+		}
+		if err := healthCheck.parseTyped(healthCheck.Min); healthCheck.Min != "" && err != nil {
+			return "min", err
+		}
+		if err := healthCheck.parseTyped(healthCheck.Max); healthCheck.Max != "" && err != nil {
+			return "max", err
+		}
+	case "within":
+		if _, err := tolerance(healthCheck.Expected, healthCheck.Tolerance); err != nil {
+			return "tolerance", err
+		}
+	case "contains", "is_null", "not_null":
+	default:
+		if err := healthCheck.parseTyped(healthCheck.Expected); len(healthCheck.Expected) > 0 && err != nil {
+			return "expected", err
+		}
+	}
+	return "", nil
+}
+
+// parseTyped checks value parses as the healthcheck's Type, when it has one
+func (healthCheck SQLHealthCheck) parseTyped(value string) error {
+	if healthCheck.Type == "" {
+		return nil
+	}
+	return parseValue(value, healthCheck.Type)
+}
+
+// checkOperation evaluates the healthcheck's operation against one field of result
+func (healthCheck SQLHealthCheck) checkOperation(result resultSet, column int, dataType string) (bool, error) {
+	answer := result.value(0, column)
+
+	switch operation := strings.ToLower(healthCheck.Operation); operation {
+	case "is_null":
+		return result.isNull(0, column), nil
+	case "not_null":
+		return !result.isNull(0, column), nil
+	case "regex":
+		re, err := regexp.Compile(healthCheck.Expected)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(answer), nil
+	case "contains":
+		return strings.Contains(answer, healthCheck.Expected), nil
+	case "in":
+		for _, value := range healthCheck.Values {
+			cmp, err := compareValues(value, answer, dataType)
+			if err != nil {
+				return false, err
+			}
+			if cmp == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "between":
+		if healthCheck.Min != "" {
+			cmp, err := compareValues(healthCheck.Min, answer, dataType)
+			if err != nil || cmp > 0 {
+				return false, err
+			}
+		}
+		if healthCheck.Max != "" {
+			cmp, err := compareValues(answer, healthCheck.Max, dataType)
+			if err != nil || cmp > 0 {
+				return false, err
+			}
+		}
+		return true, nil
+	case "within":
+		return withinTolerance(healthCheck.Expected, answer, healthCheck.Tolerance)
+	default:
+		if len(healthCheck.Expected) == 0 {
+			return true, nil
+		}
+		return compareOperation(operation, healthCheck.Expected, answer, dataType)
+	}
+}
+
+// tolerance returns the allowed absolute difference from expected, given
+// either as a number or as a percentage of expected such as "5%"
+func tolerance(expected string, allowed string) (*big.Rat, error) {
+	if allowed == "" {
+		return nil, fmt.Errorf("operation within needs a tolerance")
+	}
+	center, ok := new(big.Rat).SetString(strings.TrimSpace(expected))
+	if !ok {
+		return nil, fmt.Errorf("operation within needs a numeric expected value, got %q", expected)
+	}
+	return parseTolerance(center, allowed)
+}
+
+// parseTolerance reads an amount, or a percentage of center such as "5%"
+func parseTolerance(center *big.Rat, allowed string) (*big.Rat, error) {
+	percent := strings.HasSuffix(strings.TrimSpace(allowed), "%")
+	amount, ok := new(big.Rat).SetString(strings.TrimSuffix(strings.TrimSpace(allowed), "%"))
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid tolerance %q", allowed)
+	}
+	if percent {
+		amount.Mul(amount, new(big.Rat).Abs(center))
+		amount.Quo(amount, big.NewRat(100, 1))
+	}
+	return amount, nil
+}
+
+// withinTolerance is true when actual differs from expected by at most allowed
+func withinTolerance(expected string, actual string, allowed string) (bool, error) {
+	limit, err := tolerance(expected, allowed)
+	if err != nil {
+		return false, err
+	}
+	center, _ := new(big.Rat).SetString(strings.TrimSpace(expected))
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(actual))
+	if !ok {
+		return false, fmt.Errorf("cannot compare %q as numeric", actual)
+	}
+	difference := new(big.Rat).Sub(value, center)
+	return difference.Abs(difference).Cmp(limit) <= 0, nil
+}
@@ -0,0 +1,103 @@
+package healthcheck
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cfpb/rhobot/internal/report"
+)
+
+// Problem is something wrong with a healthcheck definition
+type Problem struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// String formats a problem as file:line: "title" field: message
+func (problem Problem) String() string {
+	var parts []string
+	if problem.File != "" {
+		location := problem.File
+		if problem.Line > 0 {
+			location = fmt.Sprintf("%s:%d", location, problem.Line)
+		}
+		parts = append(parts, location+":")
+	}
+	if problem.Title != "" {
+		parts = append(parts, fmt.Sprintf("%q", problem.Title))
+	}
+	if problem.Field != "" {
+		parts = append(parts, problem.Field+":")
+	}
+	parts = append(parts, problem.Message)
+	return strings.Join(parts, " ")
+}
+
+// ValidSeverity reports whether severity is one of report.LogLevelArray
+func ValidSeverity(severity string) bool {
+	for _, level := range report.LogLevelArray {
+		if strings.EqualFold(severity, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Problems lists everything wrong with a healthcheck definition
+func (healthCheck SQLHealthCheck) Problems() (problems []Problem) {
+	add := func(field string, message string, args ...interface{}) {
+		problems = append(problems, Problem{Field: field, Message: fmt.Sprintf(message, args...)})
+	}
+
+	if len(healthCheck.Expected) == 0 && healthCheck.usesExpected() && !healthCheck.assertsResultSet() {
+		add("expected", "is required")
+	}
+	if len(healthCheck.Kind) > 0 {
+		if field, err := healthCheck.validateKind(); err != nil {
+			add(field, "%v", err)
+		}
+	} else if len(healthCheck.Query) == 0 {
+		add("query", "is required")
+	}
+	if len(healthCheck.Title) == 0 {
+		add("title", "is required")
+	}
+	if len(healthCheck.Severity) == 0 {
+		add("severity", "is required")
+	} else if !ValidSeverity(healthCheck.Severity) {
+		add("severity", "unknown severity %q, use one of %v", healthCheck.Severity, report.LogLevelArray)
+	}
+
+	if healthCheck.Retries != nil && *healthCheck.Retries < 0 {
+		add("retries", "can not be negative")
+	}
+	if healthCheck.RetryDelay < 0 {
+		add("retry_delay", "can not be negative")
+	}
+
+	if len(healthCheck.Type) > 0 && !ValidDataType(healthCheck.Type) {
+		add("type", "unknown type %q, use one of %v", healthCheck.Type, DataTypes)
+	}
+
+	if healthCheck.Baseline != nil {
+		if err := healthCheck.Baseline.validate(); err != nil {
+			add("baseline", "%v", err)
+		}
+	}
+	if healthCheck.Anomaly != nil {
+		if healthCheck.Baseline != nil {
+			add("anomaly", "can not be used together with a baseline")
+		}
+		if err := healthCheck.Anomaly.validate(); err != nil {
+			add("anomaly", "%v", err)
+		}
+	}
+
+	if field, err := healthCheck.validateOperation(); err != nil {
+		add(field, "%v", err)
+	}
+	return
+}
@@ -0,0 +1,198 @@
+package healthcheck
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxRenderedRows limits how many rows of a result set are kept in Actual
+const maxRenderedRows = 20
+
+// resultSet holds every row and column returned by a healthcheck query
+type resultSet struct {
+	columns []string
+	types   []string
+	rows    [][]sql.NullString
+}
+
+// readResultSet scans all rows from a query into a resultSet
+func readResultSet(rows *sql.Rows) (result resultSet, err error) {
+	result.columns, err = rows.Columns()
+	if err != nil {
+		return
+	}
+
+	result.types = make([]string, len(result.columns))
+	columnTypes, typeErr := rows.ColumnTypes()
+	for i := range result.types {
+		result.types[i] = "text"
+		if typeErr == nil && i < len(columnTypes) {
+			result.types[i] = DetectType(columnTypes[i].DatabaseTypeName())
+		}
+	}
+
+	for rows.Next() {
+		row := make([]sql.NullString, len(result.columns))
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		result.rows = append(result.rows, row)
+	}
+	err = rows.Err()
+	return
+}
+
+// columnIndex finds a column by name, an empty name is the first column
+func (result resultSet) columnIndex(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for i, column := range result.columns {
+		if column == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in result", name)
+}
+
+// value returns the text of a single field, or "" when it does not exist
+func (result resultSet) value(row int, column int) string {
+	if row >= len(result.rows) || column >= len(result.rows[row]) {
+		return ""
+	}
+	return result.rows[row][column].String
+}
+
+// isNull is true when a field is NULL or does not exist
+func (result resultSet) isNull(row int, column int) bool {
+	if row >= len(result.rows) || column >= len(result.rows[row]) {
+		return true
+	}
+	return !result.rows[row][column].Valid
+}
+
+// String renders the result set as a small text table
+func (result resultSet) String() string {
+	if len(result.rows) == 0 {
+		return "(0 rows)"
+	}
+
+	lines := []string{strings.Join(result.columns, " | ")}
+	for i, row := range result.rows {
+		if i == maxRenderedRows {
+			lines = append(lines, "...")
+			break
+		}
+		fields := make([]string, len(row))
+		for j, field := range row {
+			if field.Valid {
+				fields[j] = field.String
+			} else {
+				fields[j] = "NULL"
+			}
+		}
+		lines = append(lines, strings.Join(fields, " | "))
+	}
+
+	if len(result.rows) == 1 {
+		lines = append(lines, "(1 row)")
+	} else {
+		lines = append(lines, fmt.Sprintf("(%d rows)", len(result.rows)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// assertsResultSet is true when a healthcheck checks more than a single value
+func (healthCheck SQLHealthCheck) assertsResultSet() bool {
+	return healthCheck.ExpectNoRows ||
+		healthCheck.ExpectedRowCount != nil ||
+		len(healthCheck.ExpectedRows) > 0 ||
+		len(healthCheck.ExpectedColumns) > 0
+}
+
+// compareResultSet checks the row count, rows and named columns of a result
+func (healthCheck SQLHealthCheck) compareResultSet(result resultSet) (bool, error) {
+	if healthCheck.ExpectNoRows && len(result.rows) != 0 {
+		return false, nil
+	}
+
+	if healthCheck.ExpectedRowCount != nil && *healthCheck.ExpectedRowCount != len(result.rows) {
+		return false, nil
+	}
+
+	if len(healthCheck.ExpectedRows) > 0 {
+		equal, err := result.matchesRows(healthCheck.ExpectedRows)
+		if err != nil || !equal {
+			return false, err
+		}
+	}
+
+	for column, expected := range healthCheck.ExpectedColumns {
+		index, err := result.columnIndex(column)
+		if err != nil {
+			return false, err
+		}
+		for row := range result.rows {
+			cmp, err := compareValues(expected, result.value(row, index), result.types[index])
+			if err != nil || cmp != 0 {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// matchesRows checks that the result holds exactly the expected rows, in any order
+func (result resultSet) matchesRows(expectedRows [][]string) (bool, error) {
+	if len(expectedRows) != len(result.rows) {
+		return false, nil
+	}
+
+	matched := make([]bool, len(result.rows))
+	for _, expected := range expectedRows {
+		if len(expected) != len(result.columns) {
+			return false, fmt.Errorf("expected row %v has %d columns, result has %d",
+				expected, len(expected), len(result.columns))
+		}
+
+		found := false
+		for i := range result.rows {
+			if matched[i] {
+				continue
+			}
+			equal, err := result.rowEquals(i, expected)
+			if err != nil {
+				return false, err
+			}
+			if equal {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rowEquals compares one result row with an expected row column by column
+func (result resultSet) rowEquals(row int, expected []string) (bool, error) {
+	for i, value := range expected {
+		cmp, err := compareValues(value, result.value(row, i), result.types[i])
+		if err != nil {
+			return false, err
+		}
+		if cmp != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
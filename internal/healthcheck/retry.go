@@ -0,0 +1,67 @@
+package healthcheck
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Attempt records one run of a healthcheck query
+type Attempt struct {
+	Started  time.Time     `yaml:"started"`
+	Duration time.Duration `yaml:"duration"`
+	Passed   bool          `yaml:"passed"`
+	Actual   string        `yaml:"actual"`
+	State    string        `yaml:"state,omitempty"`
+}
+
+// RunHealthCheckWithRetries runs a healthcheck until it passes or it has been
+// retried Retries times, waiting RetryDelay between attempts
+func (healthCheck *SQLHealthCheck) RunHealthCheckWithRetries(ctx context.Context, cxn *sql.DB) {
+	healthCheck.Attempts = nil
+	started := time.Now()
+	defer func() { healthCheck.Duration = time.Since(started) }()
+
+	for attempt := 1; ; attempt++ {
+		healthCheck.Passed, healthCheck.Equal = false, false
+		healthCheck.Actual, healthCheck.State = "", ""
+
+		attemptStarted := time.Now()
+		healthCheck.RunHealthCheckContext(ctx, cxn)
+		healthCheck.Attempts = append(healthCheck.Attempts, Attempt{
+			Started:  attemptStarted,
+			Duration: time.Since(attemptStarted),
+			Passed:   healthCheck.Passed && healthCheck.Equal,
+			Actual:   healthCheck.Actual,
+			State:    healthCheck.State,
+		})
+
+		if (healthCheck.Passed && healthCheck.Equal) || attempt > healthCheck.retries() || ctx.Err() != nil {
+			return
+		}
+
+		log.Infof("healthcheck %q attempt %d of %d failed, retrying in %v",
+			healthCheck.Title, attempt, healthCheck.retries()+1, healthCheck.RetryDelay)
+		select {
+		case <-time.After(healthCheck.RetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retries is how many times a failed healthcheck is retried,
+// an explicit 0 turns off the retries of its suite
+func (healthCheck SQLHealthCheck) retries() int {
+	if healthCheck.Retries == nil {
+		return 0
+	}
+	return *healthCheck.Retries
+}
+
+// Retried is true when a healthcheck needed more than one attempt
+func (healthCheck SQLHealthCheck) Retried() bool {
+	return len(healthCheck.Attempts) > 1
+}
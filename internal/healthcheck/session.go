@@ -0,0 +1,133 @@
+package healthcheck
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cfpb/rhobot/internal/database"
+)
+
+// Session holds settings applied before every healthcheck query of a suite
+type Session struct {
+	SearchPath      string `yaml:"search_path,omitempty"`
+	Role            string `yaml:"role,omitempty"`
+	ApplicationName string `yaml:"application_name,omitempty"`
+}
+
+// statements returns the SET LOCAL statements for the session settings
+func (session Session) statements() (statements []string) {
+	if session.SearchPath != "" {
+		var schemas []string
+		for _, schema := range strings.Split(session.SearchPath, ",") {
+			schemas = append(schemas, quoteIdentifier(strings.TrimSpace(schema)))
+		}
+		statements = append(statements, "SET LOCAL search_path TO "+strings.Join(schemas, ", "))
+	}
+	if session.Role != "" {
+		statements = append(statements, "SET LOCAL ROLE "+quoteIdentifier(session.Role))
+	}
+	if session.ApplicationName != "" {
+		statements = append(statements, "SET LOCAL application_name TO "+quoteLiteral(session.ApplicationName))
+	}
+	return
+}
+
+// quoteLiteral single quotes a string for use as a SQL value
+func quoteLiteral(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// ReadOnlyMode is true when every healthcheck in the suite runs read only
+func (healthChecks *Format) ReadOnlyMode() bool {
+	for _, test := range healthChecks.Tests {
+		if !healthChecks.withDefaults(test).readOnly() {
+			return false
+		}
+	}
+	return healthChecks.ReadOnly == nil || *healthChecks.ReadOnly
+}
+
+// readOnly is true unless the healthcheck's suite turned off read_only
+func (healthCheck SQLHealthCheck) readOnly() bool {
+	return healthCheck.ReadOnly == nil || *healthCheck.ReadOnly
+}
+
+// query runs the healthcheck query. Read only healthchecks, and those with
+// session settings, run in a transaction which the returned function ends,
+// always rolling it back when read only. SQLite ignores read only transactions,
+// so its connection is set query_only for the healthcheck instead.
+func (healthCheck *SQLHealthCheck) query(ctx context.Context, cxn *sql.DB) (*sql.Rows, func(), error) {
+	statements := healthCheck.Session.statements()
+	if !healthCheck.readOnly() && len(statements) == 0 {
+		rows, err := cxn.QueryContext(ctx, healthCheck.Query, healthCheck.Args...)
+		return rows, func() {}, err
+	}
+
+	if len(statements) > 0 && !database.DialectOf(cxn).Sessions() {
+		return nil, nil, fmt.Errorf("session settings are not supported by %s", database.DialectOf(cxn))
+	}
+
+	begin, release := cxn.BeginTx, func() {}
+	if healthCheck.readOnly() && database.DialectOf(cxn) == database.SQLite {
+		conn, err := queryOnly(ctx, cxn)
+		if err != nil {
+			return nil, nil, err
+		}
+		begin, release = conn.BeginTx, func() {
+			if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = OFF"); err != nil {
+				log.Errorf("healthcheck %q could not turn off query_only: %v", healthCheck.Title, err)
+			}
+			conn.Close()
+		}
+	}
+
+	tx, err := begin(ctx, &sql.TxOptions{ReadOnly: healthCheck.readOnly()})
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("could not start transaction: %v", err)
+	}
+
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			release()
+			return nil, nil, err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, healthCheck.Query, healthCheck.Args...)
+	if err != nil {
+		tx.Rollback()
+		release()
+		return nil, nil, err
+	}
+
+	end := func() {
+		defer release()
+		if healthCheck.readOnly() {
+			tx.Rollback()
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Errorf("healthcheck %q could not commit: %v", healthCheck.Title, err)
+		}
+	}
+	return rows, end, nil
+}
+
+// queryOnly takes a connection from cxn that refuses to write,
+// until PRAGMA query_only is turned off again
+func queryOnly(ctx context.Context, cxn *sql.DB) (*sql.Conn, error) {
+	conn, err := cxn.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get a connection: %v", err)
+	}
+	if _, err = conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not make the connection read only: %v", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,152 @@
+package healthcheck
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Target is a named database connection a suite runs against
+type Target struct {
+	Name  string `yaml:"name" jsonschema:"required"`
+	DBURI string `yaml:"dburi" jsonschema:"required"`
+}
+
+// TargetsFormat is for unmarshiling a targets file
+type TargetsFormat struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// TargetResult holds the results of running a suite against one target
+type TargetResult struct {
+	Target  Target
+	Results []SQLHealthCheck
+	Errors  []HCError
+}
+
+// Connector opens the connection to a target
+type Connector func(target Target) (*sql.DB, error)
+
+// ReadTargetsFromFile loads targets from a YAML file, substituting variables
+// into their connection URIs
+func ReadTargetsFromFile(path string, vars map[string]string) ([]Target, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var format TargetsFormat
+	if err = yaml.Unmarshal(data, &format); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err = resolveTargetVars(format.Targets, vars); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err = CheckTargets(format.Targets); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return format.Targets, nil
+}
+
+// resolveTargetVars substitutes variables, such as passwords from the
+// environment, into the connection URIs of targets
+func resolveTargetVars(targets []Target, vars map[string]string) (err error) {
+	for i := range targets {
+		if targets[i].DBURI, err = expandVars(targets[i].DBURI, vars); err != nil {
+			return fmt.Errorf("target %q: %v", targets[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// CheckTargets makes sure every target has a unique name and a connection URI
+func CheckTargets(targets []Target) error {
+	names := make(map[string]bool)
+	for _, target := range targets {
+		if target.Name == "" || target.DBURI == "" {
+			return fmt.Errorf("every target needs a name and a dburi")
+		}
+		if names[target.Name] {
+			return fmt.Errorf("target %q is defined more than once", target.Name)
+		}
+		names[target.Name] = true
+	}
+	return nil
+}
+
+// PreformHealthChecksOnTargets runs the suite against every target, one after
+// another or all at once when parallel is set. Each connection is closed when
+// its run is done. A results table History reads the history of each target.
+func (healthChecks *Format) PreformHealthChecksOnTargets(targets []Target, connect Connector, parallel bool) []TargetResult {
+	runs := make([]TargetResult, len(targets))
+
+	var wg sync.WaitGroup
+	for i := range targets {
+		if !parallel {
+			runs[i] = healthChecks.preformOnTarget(targets[i], connect)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runs[i] = healthChecks.preformOnTarget(targets[i], connect)
+		}(i)
+	}
+	wg.Wait()
+	return runs
+}
+
+// preformOnTarget runs a copy of the suite against a single target
+func (healthChecks *Format) preformOnTarget(target Target, connect Connector) (run TargetResult) {
+	run.Target = target
+	log.Infof("Running healthchecks against target %v", target.Name)
+
+	suite := *healthChecks
+	if table, ok := suite.History.(ResultsTable); ok {
+		table.Target = target.Name
+		suite.History = table
+	}
+
+	cxn, err := connect(target)
+	if err != nil {
+		log.Errorf("could not connect to target %q: %v", target.Name, err)
+		for _, test := range suite.Tests {
+			test.Actual = fmt.Sprintf("could not connect to target %q: %v", target.Name, err)
+			run.Results = append(run.Results, test)
+			run.Errors = append(run.Errors, test.EvaluateHealthCheck())
+		}
+	} else {
+		defer cxn.Close()
+		run.Results, run.Errors = suite.PreformHealthChecks(cxn)
+	}
+
+	for i := range run.Results {
+		run.Results[i].Target = target.Name
+	}
+	return
+}
+
+// CombineTargetResults joins the results and errors of every target, in target order
+func CombineTargetResults(runs []TargetResult) (results []SQLHealthCheck, errors []HCError) {
+	for _, run := range runs {
+		results = append(results, run.Results...)
+		errors = append(errors, run.Errors...)
+	}
+	return
+}
+
+// StatusTargets summarises the status of each target from the severity of its
+// failed healthchecks, such as "dev PASS, prod FATAL"
+func StatusTargets(runs []TargetResult) string {
+	var statuses []string
+	for _, run := range runs {
+		numErrors, numWarnings, fatal := EvaluateHCErrors(run.Errors)
+		statuses = append(statuses, fmt.Sprintf("%s %s", run.Target.Name, StatusHealthchecks(numErrors, numWarnings, fatal)))
+	}
+	return strings.Join(statuses, ", ")
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/cfpb/rhobot/internal/database"
 )
 
 // TemplateHealthcheckPostgres pongo2 template for healthchecks INSERT
@@ -21,12 +23,48 @@ CREATE TABLE IF NOT EXISTS {{metadata.schema}}.{{metadata.table}}
   "timestamp" timestamp with time zone
 );
 
-INSERT INTO "{{metadata.schema}}"."{{metadata.table}}" ("title", "query", "executed", "expected", "operation", "actual", "equal", "severity", "timestamp") VALUES
+ALTER TABLE {{metadata.schema}}.{{metadata.table}} ADD COLUMN IF NOT EXISTS target text;
+ALTER TABLE {{metadata.schema}}.{{metadata.table}} ADD COLUMN IF NOT EXISTS suite text;
+
+INSERT INTO "{{metadata.schema}}"."{{metadata.table}}" ("title", "query", "executed", "expected", "operation", "actual", "equal", "severity", "timestamp", "target", "suite") VALUES
 {% for element in elements %}
-('{{ element.Title }}', '{{ element.Query | safe | addquote }}', '{{ element.Passed}}', '{{ element.Expected  | safe | addquote  }}', '{{ element.Operation  | safe | addquote  }}', '{{ element.Actual  | safe | addquote  }}', '{{ element.Equal  | safe | addquote  }}', '{{ element.Severity }}', '{{ metadata.timestamp }}') ` +
+('{{ element.Title }}', '{{ element.Query | safe | addquote }}', '{{ element.Passed}}', '{{ element.Expected  | safe | addquote  }}', '{{ element.Operation  | safe | addquote  }}', '{{ element.Actual  | safe | addquote  }}', '{{ element.Equal  | safe | addquote  }}', '{{ element.Severity }}', '{{ metadata.timestamp }}', '{{ element.Target | safe | addquote }}', '{{ element.Suite | safe | addquote }}') ` +
 	`{% if forloop.Last%};{%else%},{%endif%}` +
 	`{% endfor %}`
 
+// TemplateHealthcheckSQLite pongo2 template for healthchecks INSERT into SQLite,
+// where the schema is main or the name of an attached database
+const TemplateHealthcheckSQLite = `
+CREATE TABLE IF NOT EXISTS "{{metadata.schema}}"."{{metadata.table}}"
+(
+  title text,
+  query text,
+  executed text,
+  expected text,
+  operation text,
+  actual text,
+  equal text,
+  severity text,
+  "timestamp" text,
+  target text,
+  suite text
+);
+
+INSERT INTO "{{metadata.schema}}"."{{metadata.table}}" ("title", "query", "executed", "expected", "operation", "actual", "equal", "severity", "timestamp", "target", "suite") VALUES
+{% for element in elements %}
+('{{ element.Title | safe | addquote }}', '{{ element.Query | safe | addquote }}', '{{ element.Passed}}', '{{ element.Expected  | safe | addquote  }}', '{{ element.Operation  | safe | addquote  }}', '{{ element.Actual  | safe | addquote  }}', '{{ element.Equal  | safe | addquote  }}', '{{ element.Severity }}', strftime('%Y-%m-%d %H:%M:%f', 'now'), '{{ element.Target | safe | addquote }}', '{{ element.Suite | safe | addquote }}') ` +
+	`{% if forloop.Last%};{%else%},{%endif%}` +
+	`{% endfor %}`
+
+// TemplateHealthcheckResults returns the template that saves healthcheck
+// results in a database of the given dialect
+func TemplateHealthcheckResults(dialect database.Dialect) string {
+	if dialect == database.SQLite {
+		return TemplateHealthcheckSQLite
+	}
+	return TemplateHealthcheckPostgres
+}
+
 // TemplateHealthcheckHTML pongo2 template for healthchecks
 const TemplateHealthcheckHTML = `
 <html>
@@ -80,23 +118,29 @@ body, p, h1, h3, ul, table {
 
 <h2>{{ metadata.status }}</h2>
 <h2>{{ metadata.name }} - Running against database "{{ metadata.db_name }}"</h2>
+{% if metadata.read_only %}<h3>Ran read only, every healthcheck was rolled back</h3>{% elif metadata.read_only == false %}<h3>Ran read write, healthchecks were not rolled back</h3>{% endif %}
 <table>
 	<tr>
+		{% if metadata.targets %}<td class = "header_field" >Target</td>{% endif %}
 		<td class = "header_field" >Title</td>
 		<td class = "header_field" >Severity</td>
 		<td class = "header_field" >Query</td>
 		<td class = "header_field" >Test Ran?</td>
 		<td class = "header_field" >Expected</td>
 		<td class = "header_field" >Operation</td>
+		<td class = "header_field" >Type</td>
 		<td class = "header_field" >Actual</td>
 	</tr>
 	{% for element in elements %}
 	<tr>
+		{% if metadata.targets %}<td class = "data" >{{ element.Target }}</td>{% endif %}
 		<td class = "data" >{{ element.Title }}</td>
 		<td class = "data" >{{ element.Severity }}</td>
 		<td class = "data" >{{ element.Query }}</td>
 
-		{% if element.Equal == "TRUE"%}
+		{% if element.State == "SKIPPED"%}
+			{% set bg_equals = "LightGray" %}
+		{% elif element.Equal == "TRUE"%}
 			{% set bg_equals = "MediumSeaGreen" %}
 		{% elif element.Equal == "FALSE" and element.Severity == "WARN"%}
 			{% set bg_equals = "LightGoldenRodYellow" %}
@@ -104,13 +148,14 @@ body, p, h1, h3, ul, table {
 			{% set bg_equals = "LightCoral" %}
 		{% endif %}
 
-		<td class = "data"  bgcolor={{bg_equals}}>{{ element.Passed }}</td>
+		<td class = "data"  bgcolor={{bg_equals}}>{% if element.State %}{{ element.State }}{% else %}{{ element.Passed }}{% endif %}{% if element.Attempts != "0" and element.Attempts != "1" %} after {{ element.Attempts }} attempts{% endif %}</td>
 		{% if element.Passed == "SUCCESS"%}
 		<td class = "data"  bgcolor={{bg_equals}}>{{ element.Expected }}</td>
 		<td class = "data"  bgcolor={{bg_equals}}>{{ element.Operation }}</td>
+		<td class = "data"  bgcolor={{bg_equals}}>{{ element.Type }}</td>
 		<td class = "data"  bgcolor={{bg_equals}}>{{ element.Actual }}</td>
 		{% else %}
-		<td class = "data"  bgcolor={{bg_equals}} colspan="3">{{ element.Error }}</td>
+		<td class = "data"  bgcolor={{bg_equals}} colspan="4">{{ element.Actual }}</td>
 		{% endif %}
 
 	{% endfor %}
@@ -149,6 +194,39 @@ func SubjectHealthcheck(name string, dbName string, hostname string, level strin
 	return subjectStr
 }
 
+// SubjectHealthcheckTag creates a subject for a healthcheck email routed by tag
+func SubjectHealthcheckTag(name string, dbName string, hostname string, tag string, errors int, warnings int, fatal bool) string {
+
+	hcName := name
+	if name == "" {
+		hcName = "healthchecks"
+	}
+
+	subjectStr := fmt.Sprintf("%s - %s - %s - %s tag",
+		hcName, dbName, hostname, tag)
+
+	statusStr := StatusHealthchecks(errors, warnings, fatal)
+	subjectStr = fmt.Sprintf("%s - %s", statusStr, subjectStr)
+
+	return subjectStr
+}
+
+// SubjectHealthcheckTargets creates a subject for a healthcheck email covering
+// several targets, scope being for instance "ERROR level" or "pii tag"
+func SubjectHealthcheckTargets(name string, scope string, runs []TargetResult) string {
+
+	hcName := name
+	if name == "" {
+		hcName = "healthchecks"
+	}
+
+	_, errors := CombineTargetResults(runs)
+	numErrors, numWarnings, fatal := EvaluateHCErrors(errors)
+
+	return fmt.Sprintf("%s - %s - %v targets (%s) - %s",
+		StatusHealthchecks(numErrors, numWarnings, fatal), hcName, len(runs), StatusTargets(runs), scope)
+}
+
 // StatusHealthchecks returns a simple summary for all healthchecks
 func StatusHealthchecks(errors int, warnings int, fatal bool) string {
 
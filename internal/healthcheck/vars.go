@@ -0,0 +1,169 @@
+package healthcheck
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// varReference matches ${name} and ${name|ident} in healthcheck fields
+var varReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(\|ident)?\}`)
+
+// ParseVars reads key=value pairs, as given to --var, into a map
+func ParseVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, pair := range pairs {
+		split := strings.Index(pair, "=")
+		if split < 1 {
+			return nil, fmt.Errorf("variable %q is not in the form key=value", pair)
+		}
+		vars[pair[:split]] = pair[split+1:]
+	}
+	return vars, nil
+}
+
+// ResolveVars substitutes variables into every healthcheck.
+// Values given in overrides win over the file's vars block, which wins over
+// environment variables. Variables in a query are bound as parameters,
+// or quoted as an identifier when written ${name|ident}. Healthchecks with
+// a kind get their query written here.
+func (healthChecks *Format) ResolveVars(overrides map[string]string) error {
+	vars := make(map[string]string)
+	for key, value := range healthChecks.Vars {
+		vars[key] = value
+	}
+	for key, value := range overrides {
+		vars[key] = value
+	}
+	healthChecks.Vars = vars
+
+	name, err := expandVars(healthChecks.Name, vars)
+	if err != nil {
+		return fmt.Errorf("name: %v", err)
+	}
+	healthChecks.Name = name
+
+	if err := resolveTargetVars(healthChecks.Targets, vars); err != nil {
+		return err
+	}
+
+	for i := range healthChecks.Tests {
+		if err := healthChecks.Tests[i].resolveVars(vars); err != nil {
+			return fmt.Errorf("healthcheck %q: %v", healthChecks.Tests[i].Title, err)
+		}
+	}
+	return nil
+}
+
+// resolveVars substitutes variables into a single healthcheck
+func (healthCheck *SQLHealthCheck) resolveVars(vars map[string]string) (err error) {
+	if healthCheck.Title, err = expandVars(healthCheck.Title, vars); err != nil {
+		return
+	}
+	if healthCheck.Expected, err = expandVars(healthCheck.Expected, vars); err != nil {
+		return
+	}
+	for i := range healthCheck.Values {
+		if healthCheck.Values[i], err = expandVars(healthCheck.Values[i], vars); err != nil {
+			return
+		}
+	}
+	if healthCheck.Min, err = expandVars(healthCheck.Min, vars); err != nil {
+		return
+	}
+	if healthCheck.Max, err = expandVars(healthCheck.Max, vars); err != nil {
+		return
+	}
+	for _, row := range healthCheck.ExpectedRows {
+		for j := range row {
+			if row[j], err = expandVars(row[j], vars); err != nil {
+				return
+			}
+		}
+	}
+	for column, value := range healthCheck.ExpectedColumns {
+		if healthCheck.ExpectedColumns[column], err = expandVars(value, vars); err != nil {
+			return
+		}
+	}
+	if healthCheck.Kind != "" {
+		if err = healthCheck.compileKind(vars); err != nil {
+			return
+		}
+	}
+	healthCheck.Query, healthCheck.Args, err = bindVars(healthCheck.Query, healthCheck.Args, vars)
+	return
+}
+
+// lookupVar finds a variable in vars, then in the environment
+func lookupVar(name string, vars map[string]string) (string, error) {
+	if value, ok := vars[name]; ok {
+		return value, nil
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("variable %q is not defined", name)
+}
+
+// expandVars replaces variable references with their text
+func expandVars(text string, vars map[string]string) (string, error) {
+	var err error
+	expanded := varReference.ReplaceAllStringFunc(text, func(reference string) string {
+		match := varReference.FindStringSubmatch(reference)
+		value, lookupErr := lookupVar(match[1], vars)
+		if lookupErr != nil {
+			err = lookupErr
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// bindVars replaces variable references in a query with numbered parameters,
+// appending their values to args, and quotes ${name|ident} references.
+// A reference inside a quoted string would become the literal text $1,
+// so it is an error.
+func bindVars(query string, args []interface{}, vars map[string]string) (string, []interface{}, error) {
+	for _, location := range varReference.FindAllStringIndex(query, -1) {
+		if strings.Count(query[:location[0]], "'")%2 == 1 {
+			return query, args, fmt.Errorf("%s is inside a quoted string, remove the quotes to bind it as a parameter",
+				query[location[0]:location[1]])
+		}
+	}
+
+	var err error
+	bound := make(map[string]int)
+	query = varReference.ReplaceAllStringFunc(query, func(reference string) string {
+		match := varReference.FindStringSubmatch(reference)
+		value, lookupErr := lookupVar(match[1], vars)
+		if lookupErr != nil {
+			err = lookupErr
+			return reference
+		}
+
+		if match[2] != "" {
+			return quoteIdentifier(value)
+		}
+
+		position, ok := bound[match[1]]
+		if !ok {
+			args = append(args, value)
+			position = len(args)
+			bound[match[1]] = position
+		}
+		return "$" + strconv.Itoa(position)
+	})
+	return query, args, err
+}
+
+// quoteIdentifier double quotes each part of a possibly schema qualified name
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.Replace(part, `"`, `""`, -1) + `"`
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,231 @@
+// Package jsonschema generates JSON Schemas for the yaml files rhobot reads
+// into Go structs, and checks yaml documents against them
+package jsonschema
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema version generated
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// durationPattern matches the durations time.ParseDuration accepts
+const durationPattern = `^-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`
+
+// scalarTypes are the JSON types yaml will read into a string field
+var scalarTypes = []string{"string", "number", "boolean"}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Schema is a JSON Schema, or a part of one
+type Schema map[string]interface{}
+
+// Field is a struct field as it appears in yaml
+type Field struct {
+	Name     string
+	Type     reflect.Type
+	Required bool
+}
+
+// Fields lists the yaml fields of a struct type, named the way yaml.v2 names
+// them, and marked required by a `jsonschema:"required"` tag. Fields tagged
+// `jsonschema:"-"` are written but never read, and are left out.
+func Fields(t reflect.Type) (fields []Field) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || field.Tag.Get("jsonschema") == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields = append(fields, Field{
+			Name:     name,
+			Type:     field.Type,
+			Required: field.Tag.Get("jsonschema") == "required",
+		})
+	}
+	return
+}
+
+// Generate builds the JSON Schema for the yaml representation of v
+func Generate(v interface{}, title string) Schema {
+	schema := generate(reflect.TypeOf(v))
+	schema["$schema"] = Draft
+	schema["title"] = title
+	return schema
+}
+
+// generate builds the schema for a single type
+func generate(t reflect.Type) Schema {
+	switch t {
+	case durationType:
+		return Schema{"type": []string{"string", "integer"}, "pattern": durationPattern}
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return generate(t.Elem())
+	case reflect.String:
+		return Schema{"type": scalarTypes}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": generate(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": generate(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		var required []string
+		for _, field := range Fields(t) {
+			properties[field.Name] = generate(field.Type)
+			if field.Required {
+				required = append(required, field.Name)
+			}
+		}
+		schema := Schema{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return Schema{}
+}
+
+// Validate checks a document decoded from yaml or json against schema, and
+// returns a message for everything that does not match. It understands the
+// parts of JSON Schema that Generate produces.
+func Validate(schema Schema, document interface{}) []string {
+	return validate(schema, document, "")
+}
+
+func validate(schema Schema, value interface{}, path string) (problems []string) {
+	location := path
+	if location == "" {
+		location = "/"
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		return []string{fmt.Sprintf("%s: %s is not %v", location, jsonType(value), types)}
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		if text, isString := value.(string); isString && !regexp.MustCompile(pattern).MatchString(text) {
+			problems = append(problems, fmt.Sprintf("%s: %q does not match %s", location, text, pattern))
+		}
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		if items, ok := asSchema(schema["items"]); ok {
+			for i, item := range value {
+				problems = append(problems, validate(items, item, fmt.Sprintf("%s/%d", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := asSchema(schema["properties"])
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if property, ok := asSchema(properties[key]); ok {
+				problems = append(problems, validate(property, value[key], path+"/"+key)...)
+			} else if additional, ok := asSchema(schema["additionalProperties"]); ok {
+				problems = append(problems, validate(additional, value[key], path+"/"+key)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%s: unknown property %q", location, key))
+			}
+		}
+
+		for _, key := range stringList(schema["required"]) {
+			if _, ok := value[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", location, key))
+			}
+		}
+	}
+	return
+}
+
+// asSchema accepts a generated Schema or one decoded from json
+func asSchema(v interface{}) (Schema, bool) {
+	switch v := v.(type) {
+	case Schema:
+		return v, true
+	case map[string]interface{}:
+		return Schema(v), true
+	}
+	return nil, false
+}
+
+// stringList accepts a generated []string or one decoded from json
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if text, ok := item.(string); ok {
+				list = append(list, text)
+			}
+		}
+		return list
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
+// matchesType reports whether value is one of the JSON types
+func matchesType(types interface{}, value interface{}) bool {
+	actual := jsonType(value)
+	for _, expected := range stringList(types) {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		if value == float64(int64(value)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
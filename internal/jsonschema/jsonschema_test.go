@@ -0,0 +1,84 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type child struct {
+	Name string `yaml:"name" jsonschema:"required"`
+}
+
+type parent struct {
+	Count    int               `yaml:"count,omitempty"`
+	Every    time.Duration     `yaml:"every,omitempty"`
+	Children []child           `yaml:"children"`
+	Labels   map[string]string `yaml:"labels,omitempty"`
+	Enabled  bool
+	Ignored  string `yaml:"-"`
+	Result   string `yaml:"result,omitempty" jsonschema:"-"`
+}
+
+func TestGenerate(t *testing.T) {
+	schema := Generate(parent{}, "parent")
+	if schema["$schema"] != Draft || schema["title"] != "parent" {
+		t.Errorf("schema is missing its header: %v", schema)
+	}
+
+	properties := schema["properties"].(Schema)
+	for _, name := range []string{"count", "every", "children", "labels", "enabled"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("property %q is missing", name)
+		}
+	}
+	if _, ok := properties["ignored"]; ok {
+		t.Error("fields tagged yaml:\"-\" should not be in the schema")
+	}
+	if _, ok := properties["result"]; ok {
+		t.Error("fields tagged jsonschema:\"-\" should not be in the schema")
+	}
+
+	items := properties["children"].(Schema)["items"].(Schema)
+	if required := items["required"].([]string); len(required) != 1 || required[0] != "name" {
+		t.Errorf("name should be required, got %v", items["required"])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := Generate(parent{}, "parent")
+
+	valid := map[string]interface{}{
+		"count":    3,
+		"every":    "1h30m",
+		"children": []interface{}{map[string]interface{}{"name": 7}},
+		"labels":   map[string]interface{}{"team": "data"},
+		"enabled":  true,
+	}
+	if problems := Validate(schema, valid); len(problems) > 0 {
+		t.Errorf("valid document had problems: %v", problems)
+	}
+
+	invalid := map[string]interface{}{
+		"count":    "three",
+		"every":    "soon",
+		"children": []interface{}{map[string]interface{}{"nmae": "x"}},
+		"labels":   map[string]interface{}{"team": []interface{}{}},
+	}
+	expected := []string{
+		`/children/0: unknown property "nmae"`,
+		`/children/0: missing required property "name"`,
+		`/count: string is not integer`,
+		`/every: "soon" does not match`,
+		`/labels/team: array is not [string number boolean]`,
+	}
+	problems := Validate(schema, invalid)
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], problem)
+		}
+	}
+}
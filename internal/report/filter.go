@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/cfpb/rhobot/internal/jsonschema"
 	"github.com/davecgh/go-spew/spew"
 	"gopkg.in/yaml.v2"
 )
@@ -38,6 +39,31 @@ func FilterReportSet(rs Set, logLevel string) Set {
 	return filteredSet
 }
 
+// FilterReportSetByTag keeps the elements with tag in their comma separated Tags
+func FilterReportSetByTag(rs Set, tag string) Set {
+
+	filteredElements := make([]Element, 0)
+
+	for _, elm := range rs.GetElementArray() {
+		if tagIncludes(elm, tag) {
+			filteredElements = append(filteredElements, elm)
+		}
+	}
+
+	filteredSet := Set{Elements: filteredElements, Metadata: rs.Metadata}
+	return filteredSet
+}
+
+// tagIncludes utility function to know if an element is tagged with tag
+func tagIncludes(elm Element, tag string) bool {
+	for _, elmTag := range strings.Split(elm.GetValue("Tags"), ",") {
+		if strings.TrimSpace(elmTag) == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // logLevelIncludes utility function to know if one loglevel includes another
 func logLevelIncludes(elm Element, logLevel string) bool {
 
@@ -75,6 +101,12 @@ type DistributionFormat struct {
 		Error []string `yaml:"error,omitempty"`
 		Fatal []string `yaml:"fatal,omitempty"`
 	} `yaml:"severity"`
+	Tags map[string][]string `yaml:"tags,omitempty"`
+}
+
+// DistributionSchema returns the JSON Schema of a distribution list file
+func DistributionSchema() jsonschema.Schema {
+	return jsonschema.Generate(DistributionFormat{}, "rhobot distribution list file")
 }
 
 // ReadDistributionFormatYAMLFromFile loads DistributionFormat data from a YAML file
@@ -107,3 +139,8 @@ func (df DistributionFormat) GetEmails(level string) []string {
 	}
 	return nil
 }
+
+// GetTagEmails returns list of emails for a healthcheck tag
+func (df DistributionFormat) GetTagEmails(tag string) []string {
+	return df.Tags[tag]
+}
@@ -115,12 +115,12 @@ func (eh EmailHandler) HandleReport(reader io.Reader) (err error) {
 	return err
 }
 
-// PGHandler initilization with sql connection
+// PGHandler initilization with sql connection, to Postgres or any other database
 type PGHandler struct {
 	Cxn *sql.DB
 }
 
-// HandleReport consumes ReportReader output, writes to postgres db
+// HandleReport consumes ReportReader output, writes to the db
 func (pg PGHandler) HandleReport(reader io.Reader) (err error) {
 
 	reportBytes, err := ioutil.ReadAll(reader)
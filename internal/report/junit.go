@@ -0,0 +1,182 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// JUnitReportRunner renders a healthcheck report set as JUnit XML, one
+// testsuite per healthcheck suite and one testcase per healthcheck
+type JUnitReportRunner struct{}
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr,omitempty"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr,omitempty"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr,omitempty"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+
+	duration time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Skipped   *junitMessage `xml:"skipped"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// ReportReader Implementation for JUnitReportRunner
+func (jur JUnitReportRunner) ReportReader(reportSet Set) (io.Reader, error) {
+	name, _ := reportSet.Metadata["name"].(string)
+	timestamp := junitTimestamp(reportSet.Metadata["timestamp"])
+
+	root := junitTestSuites{Name: name}
+	var duration time.Duration
+	suites := make(map[string]int)
+	for _, element := range reportSet.Elements {
+		suiteName := element.GetValue("Suite")
+		if suiteName == "" {
+			suiteName = name
+		}
+		i, ok := suites[suiteName]
+		if !ok {
+			i = len(root.Suites)
+			suites[suiteName] = i
+			root.Suites = append(root.Suites, junitTestSuite{Name: suiteName, Timestamp: timestamp})
+		}
+		suite := &root.Suites[i]
+
+		testCase := junitCase(element, suiteName)
+		elapsed, _ := time.ParseDuration(element.GetValue("Duration"))
+		suite.duration += elapsed
+		duration += elapsed
+
+		suite.Tests++
+		switch {
+		case testCase.Skipped != nil:
+			suite.Skipped++
+		case testCase.Error != nil:
+			suite.Errors++
+		case testCase.Failure != nil:
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	for i := range root.Suites {
+		suite := &root.Suites[i]
+		suite.Time = junitSeconds(suite.duration)
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Errors += suite.Errors
+		root.Skipped += suite.Skipped
+	}
+	root.Time = junitSeconds(duration)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buffer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	buffer.WriteString("\n")
+	return &buffer, nil
+}
+
+// junitCase turns one healthcheck into a testcase. A healthcheck that was
+// skipped is skipped, one whose query did not run is an error and one whose
+// result was not as expected is a failure.
+func junitCase(element Element, suiteName string) junitTestCase {
+	testCase := junitTestCase{
+		Name:      element.GetValue("Title"),
+		Classname: suiteName,
+		SystemOut: element.GetValue("Query"),
+	}
+	if target := element.GetValue("Target"); target != "" {
+		testCase.Classname = suiteName + "." + target
+	}
+	if elapsed, err := time.ParseDuration(element.GetValue("Duration")); err == nil {
+		testCase.Time = junitSeconds(elapsed)
+	}
+
+	severity := element.GetValue("Severity")
+	details := junitDetails(element)
+	switch {
+	case element.GetValue("State") == "SKIPPED":
+		testCase.Skipped = &junitMessage{Message: "a healthcheck it depends on failed"}
+	case element.GetValue("Passed") == "FAIL":
+		message := element.GetValue("Actual")
+		if state := element.GetValue("State"); state != "" {
+			message = strings.ToLower(state) + ": " + message
+		}
+		testCase.Error = &junitMessage{Message: message, Type: severity, Text: details}
+	case element.GetValue("Equal") == "FALSE":
+		testCase.Failure = &junitMessage{
+			Message: fmt.Sprintf("expected %s, actual %s", element.GetValue("Expected"), element.GetValue("Actual")),
+			Type:    severity,
+			Text:    details,
+		}
+	}
+	return testCase
+}
+
+// junitDetails describes a healthcheck in the body of a failure or error
+func junitDetails(element Element) string {
+	var details []string
+	for _, key := range []string{"Query", "Operation", "Expected", "Actual", "Severity", "Target"} {
+		if value := element.GetValue(key); value != "" {
+			details = append(details, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	return strings.Join(details, "\n")
+}
+
+// junitSeconds formats a duration as the seconds JUnit expects,
+// or nothing when there is no duration
+func junitSeconds(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
+
+// junitTimestamp converts the report timestamp, written as time.ANSIC,
+// to the ISO 8601 form JUnit expects
+func junitTimestamp(value interface{}) string {
+	timestamp, _ := value.(string)
+	parsed, err := time.Parse(time.ANSIC, timestamp)
+	if err != nil {
+		return ""
+	}
+	return parsed.Format("2006-01-02T15:04:05")
+}
@@ -0,0 +1,153 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusReportRunner renders a healthcheck report set in the Prometheus
+// text exposition format. The run is described by the metadata name,
+// started (a time.Time, or else the time.ANSIC timestamp) and duration
+// (a time.Duration).
+type PrometheusReportRunner struct{}
+
+// prometheusMetric is a metric family and the samples written for it
+type prometheusMetric struct {
+	name, help string
+	samples    []string
+}
+
+// ReportReader Implementation for PrometheusReportRunner
+func (prr PrometheusReportRunner) ReportReader(reportSet Set) (io.Reader, error) {
+	name, _ := reportSet.Metadata["name"].(string)
+
+	passed := prometheusMetric{name: "rhobot_healthcheck_passed",
+		help: "Whether the healthcheck ran and its result was as expected."}
+	actual := prometheusMetric{name: "rhobot_healthcheck_actual",
+		help: "The actual value of the healthcheck, when it is a number."}
+	duration := prometheusMetric{name: "rhobot_healthcheck_duration_seconds",
+		help: "How long the healthcheck query took to run."}
+	runTimestamp := prometheusMetric{name: "rhobot_healthcheck_run_timestamp_seconds",
+		help: "When the healthcheck run started, in seconds since the epoch."}
+	runDuration := prometheusMetric{name: "rhobot_healthcheck_run_duration_seconds",
+		help: "How long the healthcheck run took."}
+
+	for _, element := range reportSet.Elements {
+		suite := element.GetValue("Suite")
+		if suite == "" {
+			suite = name
+		}
+		labels := prometheusLabels("suite", suite, "title", element.GetValue("Title"),
+			"severity", element.GetValue("Severity"), "target", element.GetValue("Target"))
+
+		value := 0
+		if element.GetValue("Passed") == "SUCCESS" && element.GetValue("Equal") == "TRUE" {
+			value = 1
+		}
+		passed.add(labels, strconv.Itoa(value))
+
+		if number, err := strconv.ParseFloat(strings.TrimSpace(element.GetValue("Actual")), 64); err == nil {
+			actual.add(labels, prometheusFloat(number))
+		}
+		if elapsed, err := time.ParseDuration(element.GetValue("Duration")); err == nil {
+			duration.add(labels, prometheusFloat(elapsed.Seconds()))
+		}
+	}
+
+	runLabels := prometheusLabels("suite", name)
+	if started, ok := prometheusStarted(reportSet.Metadata); ok {
+		runTimestamp.add(runLabels, prometheusFloat(float64(started.UnixNano())/float64(time.Second)))
+	}
+	if elapsed, ok := reportSet.Metadata["duration"].(time.Duration); ok {
+		runDuration.add(runLabels, prometheusFloat(elapsed.Seconds()))
+	}
+
+	var buffer bytes.Buffer
+	for _, metric := range []prometheusMetric{passed, actual, duration, runTimestamp, runDuration} {
+		if len(metric.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buffer, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(&buffer, "# TYPE %s gauge\n", metric.name)
+		for _, sample := range metric.samples {
+			buffer.WriteString(metric.name + sample + "\n")
+		}
+	}
+	return &buffer, nil
+}
+
+// add appends a sample with the given labels and value
+func (metric *prometheusMetric) add(labels string, value string) {
+	metric.samples = append(metric.samples, labels+" "+value)
+}
+
+// prometheusLabels formats name, value pairs as a label set, leaving out
+// labels without a value
+func prometheusLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], value))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// prometheusFloat formats a sample value
+func prometheusFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// prometheusStarted finds when the run started in the report metadata
+func prometheusStarted(metadata map[string]interface{}) (time.Time, bool) {
+	if started, ok := metadata["started"].(time.Time); ok {
+		return started, true
+	}
+	timestamp, _ := metadata["timestamp"].(string)
+	started, err := time.ParseInLocation(time.ANSIC, timestamp, time.Local)
+	return started, err == nil
+}
+
+// TextfileHandler writes a report into a node_exporter textfile collector
+// directory. The file is written under a temporary name and renamed, so the
+// collector never reads a partly written file.
+type TextfileHandler struct {
+	Directory string
+	// Filename defaults to rhobot.prom
+	Filename string
+}
+
+// HandleReport consumes ReportReader output, replaces the textfile with it
+func (th TextfileHandler) HandleReport(reader io.Reader) error {
+	filename := th.Filename
+	if filename == "" {
+		filename = "rhobot.prom"
+	}
+
+	f, err := ioutil.TempFile(th.Directory, "."+filename+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, reader)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(th.Directory, filename))
+}
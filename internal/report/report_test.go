@@ -1,13 +1,21 @@
 package report
 
 import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 
 	"github.com/cfpb/rhobot/internal/config"
 	"github.com/cfpb/rhobot/internal/database"
+	"github.com/cfpb/rhobot/internal/jsonschema"
 )
 
 var conf *config.Config
@@ -17,6 +25,18 @@ func init() {
 	conf.SetLogLevel("info")
 }
 
+type TaggedRE struct {
+	Tags string
+}
+
+func (tre TaggedRE) GetHeaders() []string {
+	return []string{"Tags"}
+}
+
+func (tre TaggedRE) GetValue(key string) string {
+	return tre.Tags
+}
+
 type SimpleRE struct {
 	SimpleHeaders []string
 }
@@ -29,6 +49,20 @@ func (sre SimpleRE) GetValue(key string) string {
 	return "simple"
 }
 
+type MapRE map[string]string
+
+func (mre MapRE) GetHeaders() []string {
+	var headers []string
+	for header := range mre {
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+func (mre MapRE) GetValue(key string) string {
+	return mre[key]
+}
+
 func TestJSONReport(t *testing.T) {
 	var re Element
 	var rs Set
@@ -105,6 +139,36 @@ func TestPGReportToDB(t *testing.T) {
 	}
 }
 
+func TestSQLiteReportToDB(t *testing.T) {
+	cxn, err := database.Open("sqlite://" + filepath.Join(t.TempDir(), "report.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cxn.Close()
+
+	re := SimpleRE{[]string{"Something", "Nothing", "Mine", "Yours"}}
+	var templateSimpleSQLite = `
+	CREATE TABLE IF NOT EXISTS "{{metadata.schema}}"."{{metadata.table}}"("something" text, "nothing" text, "mine" text, "yours" text);
+	{% for element in elements %}
+	INSERT INTO "{{metadata.schema}}"."{{metadata.table}}" ("something", "nothing", "mine", "yours") VALUES ('{{ element.Something }}', '{{ element.Nothing }}', '{{ element.Mine}}', '{{ element.Yours }}' );
+	{% endfor %}
+	`
+
+	prr := NewPongo2ReportRunnerFromString(templateSimpleSQLite, false)
+	rs := Set{[]Element{re, re}, map[string]interface{}{"schema": "main", "table": "something"}}
+
+	reader, err := prr.ReportReader(rs)
+	err = PGHandler{cxn}.HandleReport(reader)
+	if err != nil {
+		t.Fatalf("error writing report\n%s", err)
+	}
+
+	var rows int
+	if err = cxn.QueryRow(`SELECT count(*) FROM something`).Scan(&rows); err != nil || rows != 2 {
+		t.Errorf("report was not written to sqlite, %d rows %v", rows, err)
+	}
+}
+
 func TestPongo2Report(t *testing.T) {
 	var re Element
 	var rs Set
@@ -175,3 +239,184 @@ func TestDistributionList(t *testing.T) {
 	}
 	df.Print()
 }
+
+func TestFilterReportSetByTag(t *testing.T) {
+	elements := []Element{TaggedRE{"pii"}, TaggedRE{"post-load,pii"}, TaggedRE{"post-load"}, TaggedRE{""}}
+	rs := Set{Elements: elements, Metadata: map[string]interface{}{}}
+
+	if len(FilterReportSetByTag(rs, "pii").Elements) != 2 {
+		t.Error("wrong number of elements tagged pii")
+	}
+	if len(FilterReportSetByTag(rs, "post").Elements) != 0 {
+		t.Error("tags should only match whole tags")
+	}
+}
+
+func TestDistributionListTags(t *testing.T) {
+	df, err := ReadDistributionFormatYAMLFromFile("distributionListTest.yml")
+	if err != nil {
+		t.Fatalf("Failed to read distribution format\n%s", err)
+	}
+	if emails := df.GetTagEmails("pii"); len(emails) != 1 || emails[0] != "privacy@cfpb.gov" {
+		t.Errorf("wrong emails for tag pii: %v", emails)
+	}
+	if df.GetTagEmails("missing") != nil {
+		t.Error("unknown tag should have no emails")
+	}
+}
+
+func TestDistributionSchema(t *testing.T) {
+	data, err := ioutil.ReadFile("distributionListTest.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document interface{}
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if problems := jsonschema.Validate(DistributionSchema(), document); len(problems) > 0 {
+		t.Errorf("distribution list does not match its schema: %v", problems)
+	}
+
+	document = map[string]interface{}{"severity": map[string]interface{}{"critical": []interface{}{"a@cfpb.gov"}}}
+	if problems := jsonschema.Validate(DistributionSchema(), document); len(problems) != 1 {
+		t.Errorf("unknown severity should not match the schema, got %v", problems)
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	elements := []Element{
+		MapRE{"Title": "rows loaded", "Suite": "loads", "Passed": "SUCCESS", "Equal": "TRUE", "Duration": "1.5s"},
+		MapRE{"Title": "totals match", "Suite": "loads", "Passed": "SUCCESS", "Equal": "FALSE", "Severity": "ERROR",
+			"Query": "select sum(total) from orders", "Expected": "100", "Actual": "80 < 100 & more", "Duration": "250ms"},
+		MapRE{"Title": "slow check", "Suite": "loads", "Passed": "FAIL", "Equal": "FALSE", "Severity": "WARN",
+			"State": "TIMEOUT", "Actual": "context deadline exceeded", "Target": "replica-1"},
+		MapRE{"Title": "after totals", "Suite": "reports", "Passed": "FAIL", "Equal": "FALSE", "State": "SKIPPED"},
+	}
+	metadata := map[string]interface{}{"name": "nightly", "timestamp": "Mon Jan  2 15:04:05 2006"}
+
+	reader, err := JUnitReportRunner{}.ReportReader(Set{elements, metadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+
+	var junit junitTestSuites
+	if err = xml.Unmarshal(data, &junit); err != nil {
+		t.Fatalf("report is not valid xml: %v\n%s", err, data)
+	}
+	if junit.Tests != 4 || junit.Failures != 1 || junit.Errors != 1 || junit.Skipped != 1 || junit.Time != "1.750" {
+		t.Errorf("wrong totals: %+v", junit)
+	}
+	if len(junit.Suites) != 2 || junit.Suites[0].Name != "loads" || junit.Suites[0].Timestamp != "2006-01-02T15:04:05" {
+		t.Fatalf("checks were not grouped by suite: %+v", junit.Suites)
+	}
+
+	cases := junit.Suites[0].Cases
+	if cases[0].Failure != nil || cases[0].Error != nil || cases[0].Time != "1.500" {
+		t.Errorf("passing check should be a plain testcase: %+v", cases[0])
+	}
+	if cases[1].Failure == nil || cases[1].Failure.Message != "expected 100, actual 80 < 100 & more" ||
+		!strings.Contains(cases[1].Failure.Text, "Query: select sum(total) from orders") {
+		t.Errorf("failed check should describe the failure: %+v", cases[1].Failure)
+	}
+	if cases[2].Error == nil || cases[2].Error.Message != "timeout: context deadline exceeded" || cases[2].Classname != "loads.replica-1" {
+		t.Errorf("check that did not run should be an error: %+v", cases[2])
+	}
+	if junit.Suites[1].Cases[0].Skipped == nil {
+		t.Errorf("skipped check should be skipped: %+v", junit.Suites[1].Cases[0])
+	}
+}
+
+func TestTAPReport(t *testing.T) {
+	elements := []Element{
+		MapRE{"Title": "rows loaded", "Passed": "SUCCESS", "Equal": "TRUE", "Severity": "ERROR"},
+		MapRE{"Title": "totals # match", "Passed": "SUCCESS", "Equal": "FALSE", "Severity": "ERROR",
+			"Query": "select sum(total)\nfrom orders", "Expected": "100", "Actual": "80: low", "Attempts": "1"},
+		MapRE{"Title": "row growth", "Passed": "SUCCESS", "Equal": "FALSE", "Severity": "WARN", "Expected": "10", "Actual": "9"},
+		MapRE{"Title": "after totals", "Passed": "FAIL", "Equal": "FALSE", "Severity": "ERROR", "State": "SKIPPED"},
+	}
+	reader, err := TAPReportRunner{}.ReportReader(Set{elements, map[string]interface{}{"name": "nightly"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+
+	expected := `TAP version 13
+1..4
+# nightly
+ok 1 - rows loaded
+not ok 2 - totals \# match
+  ---
+  severity: ERROR
+  expected: "100"
+  actual: '80: low'
+  query: |-
+    select sum(total)
+    from orders
+  ...
+not ok 3 - row growth # TODO warn level
+  ---
+  severity: WARN
+  expected: "10"
+  actual: "9"
+  ...
+ok 4 - after totals # SKIP a healthcheck it depends on failed
+`
+	if string(data) != expected {
+		t.Errorf("wrong TAP stream:\n%s", data)
+	}
+
+	if err = (PrintHandler{}).HandleReport(bytes.NewReader(data)); err != nil {
+		t.Errorf("TAP stream could not be printed: %v", err)
+	}
+}
+
+func TestPrometheusReport(t *testing.T) {
+	elements := []Element{
+		MapRE{"Title": "rows loaded", "Suite": "loads", "Passed": "SUCCESS", "Equal": "TRUE", "Severity": "ERROR",
+			"Actual": "1250", "Duration": "1.5s"},
+		MapRE{"Title": `say "hi"`, "Suite": "loads", "Passed": "SUCCESS", "Equal": "FALSE", "Severity": "WARN",
+			"Actual": "t", "Target": "replica-1"},
+	}
+	metadata := map[string]interface{}{
+		"name":     "nightly",
+		"started":  time.Unix(1500000000, 0),
+		"duration": 2500 * time.Millisecond,
+	}
+	reader, err := PrometheusReportRunner{}.ReportReader(Set{elements, metadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err = (TextfileHandler{Directory: dir}).HandleReport(reader); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "rhobot.prom" {
+		t.Fatalf("textfile was not renamed into place: %v", files)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "rhobot.prom"))
+
+	expected := `# HELP rhobot_healthcheck_passed Whether the healthcheck ran and its result was as expected.
+# TYPE rhobot_healthcheck_passed gauge
+rhobot_healthcheck_passed{suite="loads",title="rows loaded",severity="ERROR"} 1
+rhobot_healthcheck_passed{suite="loads",title="say \"hi\"",severity="WARN",target="replica-1"} 0
+# HELP rhobot_healthcheck_actual The actual value of the healthcheck, when it is a number.
+# TYPE rhobot_healthcheck_actual gauge
+rhobot_healthcheck_actual{suite="loads",title="rows loaded",severity="ERROR"} 1250
+# HELP rhobot_healthcheck_duration_seconds How long the healthcheck query took to run.
+# TYPE rhobot_healthcheck_duration_seconds gauge
+rhobot_healthcheck_duration_seconds{suite="loads",title="rows loaded",severity="ERROR"} 1.5
+# HELP rhobot_healthcheck_run_timestamp_seconds When the healthcheck run started, in seconds since the epoch.
+# TYPE rhobot_healthcheck_run_timestamp_seconds gauge
+rhobot_healthcheck_run_timestamp_seconds{suite="nightly"} 1.5e+09
+# HELP rhobot_healthcheck_run_duration_seconds How long the healthcheck run took.
+# TYPE rhobot_healthcheck_run_duration_seconds gauge
+rhobot_healthcheck_run_duration_seconds{suite="nightly"} 2.5
+`
+	if string(data) != expected {
+		t.Errorf("wrong prometheus textfile:\n%s", data)
+	}
+}
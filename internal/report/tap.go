@@ -0,0 +1,87 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TAPReportRunner renders a healthcheck report set as a TAP version 13 stream.
+// Skipped healthchecks get a SKIP directive and failed ones below error
+// severity a TODO directive, so only errors and fatals fail the stream.
+type TAPReportRunner struct{}
+
+// tapDiagnostics are the healthcheck fields described under a failed test point
+var tapDiagnostics = []string{"Severity", "Operation", "Expected", "Actual", "State", "Query", "Target", "Attempts", "Duration"}
+
+// ReportReader Implementation for TAPReportRunner
+func (trr TAPReportRunner) ReportReader(reportSet Set) (io.Reader, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("TAP version 13\n")
+	fmt.Fprintf(&buffer, "1..%d\n", len(reportSet.Elements))
+	if name, _ := reportSet.Metadata["name"].(string); name != "" {
+		fmt.Fprintf(&buffer, "# %s\n", tapEscape(name))
+	}
+
+	for i, element := range reportSet.Elements {
+		description := tapEscape(element.GetValue("Title"))
+
+		if element.GetValue("State") == "SKIPPED" {
+			fmt.Fprintf(&buffer, "ok %d - %s # SKIP a healthcheck it depends on failed\n", i+1, description)
+			continue
+		}
+		if element.GetValue("Passed") == "SUCCESS" && element.GetValue("Equal") == "TRUE" {
+			fmt.Fprintf(&buffer, "ok %d - %s\n", i+1, description)
+			continue
+		}
+
+		fmt.Fprintf(&buffer, "not ok %d - %s", i+1, description)
+		severity := strings.ToLower(element.GetValue("Severity"))
+		if level, ok := LogLevelMap[severity]; ok && level < LogLevelMap["error"] {
+			fmt.Fprintf(&buffer, " # TODO %s level", severity)
+		}
+		buffer.WriteString("\n")
+
+		diagnostics, err := tapYAML(element)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString(diagnostics)
+	}
+	return &buffer, nil
+}
+
+// tapYAML returns the indented YAML diagnostic block of a failed healthcheck
+func tapYAML(element Element) (string, error) {
+	var fields yaml.MapSlice
+	for _, key := range tapDiagnostics {
+		value := element.GetValue(key)
+		if value == "" || key == "Attempts" && value == "1" {
+			continue
+		}
+		fields = append(fields, yaml.MapItem{Key: strings.ToLower(key), Value: value})
+	}
+
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{"  ---"}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		lines = append(lines, "  "+line)
+	}
+	lines = append(lines, "  ...")
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// tapEscape keeps a description on one line and stops a # in it
+// from being read as a directive
+func tapEscape(description string) string {
+	description = strings.Replace(description, "\\", "\\\\", -1)
+	description = strings.Replace(description, "#", "\\#", -1)
+	return strings.Join(strings.Fields(description), " ")
+}
@@ -0,0 +1,199 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cfpb/rhobot/internal/healthcheck"
+	"github.com/cfpb/rhobot/internal/jsonschema"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// Entry runs a healthcheck suite on a cron schedule. Paths are relative to
+// the schedule file and ${NAME} in the dburi is read from the environment.
+type Entry struct {
+	Name         string            `yaml:"name" jsonschema:"required"`
+	Cron         string            `yaml:"cron" jsonschema:"required"`
+	Healthchecks string            `yaml:"healthchecks" jsonschema:"required"`
+	DBURI        string            `yaml:"dburi,omitempty"`
+	Targets      string            `yaml:"targets,omitempty"`
+	Email        string            `yaml:"email,omitempty"`
+	Report       string            `yaml:"report,omitempty"`
+	JUnit        string            `yaml:"junit,omitempty"`
+	Prometheus   string            `yaml:"prometheus,omitempty"`
+	Schema       string            `yaml:"schema,omitempty"`
+	Table        string            `yaml:"table,omitempty"`
+	FailOn       string            `yaml:"fail_on,omitempty"`
+	Vars         map[string]string `yaml:"vars,omitempty"`
+	Tags         []string          `yaml:"tags,omitempty"`
+	SkipTags     []string          `yaml:"skip_tags,omitempty"`
+}
+
+// Format is for unmarshaling a schedule file
+type Format struct {
+	Schedules []Entry `yaml:"schedules" jsonschema:"required"`
+}
+
+// FormatSchema returns the JSON Schema of a schedule file
+func FormatSchema() jsonschema.Schema {
+	return jsonschema.Generate(Format{}, "rhobot schedule file")
+}
+
+// ReadScheduleFromFile loads and checks a schedule file
+func ReadScheduleFromFile(path string) (format Format, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(data, &format); err != nil {
+		return format, fmt.Errorf("%s: %v", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range format.Schedules {
+		entry := &format.Schedules[i]
+		if err = entry.validate(); err != nil {
+			return format, fmt.Errorf("%s: %v", path, err)
+		}
+		if names[entry.Name] {
+			return format, fmt.Errorf("%s: schedule %q is defined more than once", path, entry.Name)
+		}
+		names[entry.Name] = true
+		entry.resolve(filepath.Dir(path))
+	}
+	return
+}
+
+// validate checks an entry has what it needs to run
+func (entry Entry) validate() error {
+	if entry.Name == "" {
+		return errors.New("every schedule needs a name")
+	}
+	if entry.Healthchecks == "" {
+		return fmt.Errorf("schedule %q needs healthchecks to run", entry.Name)
+	}
+	if _, err := cron.ParseStandard(entry.Cron); err != nil {
+		return fmt.Errorf("schedule %q has an invalid cron expression %q: %v", entry.Name, entry.Cron, err)
+	}
+	if entry.FailOn != "" {
+		if _, err := healthcheck.ParseFailOn(entry.FailOn); err != nil {
+			return fmt.Errorf("schedule %q: %v", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolve makes paths relative to dir and expands environment variables in the dburi
+func (entry *Entry) resolve(dir string) {
+	for _, path := range []*string{&entry.Healthchecks, &entry.Targets, &entry.Email,
+		&entry.Report, &entry.JUnit, &entry.Prometheus} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	entry.DBURI = os.ExpandEnv(entry.DBURI)
+}
+
+// Scheduler runs entries on their schedules. An entry that is still running
+// when it is due again is skipped rather than run twice at once.
+type Scheduler struct {
+	run func(Entry) error
+
+	mutex   sync.Mutex
+	cron    *cron.Cron
+	running map[string]bool
+	stopped bool
+	wait    sync.WaitGroup
+}
+
+// NewScheduler creates a Scheduler calling run for every scheduled entry
+func NewScheduler(run func(Entry) error) *Scheduler {
+	return &Scheduler{run: run, running: make(map[string]bool)}
+}
+
+// Load replaces the schedule with entries. Runs in flight carry on, and
+// still keep their entry from starting again until they are done.
+func (scheduler *Scheduler) Load(entries []Entry) error {
+	next := cron.New()
+	for _, entry := range entries {
+		entry := entry
+		if _, err := next.AddFunc(entry.Cron, func() { scheduler.trigger(entry) }); err != nil {
+			return fmt.Errorf("schedule %q: %v", entry.Name, err)
+		}
+	}
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if scheduler.stopped {
+		return errors.New("scheduler is stopped")
+	}
+	if scheduler.cron != nil {
+		scheduler.cron.Stop()
+	}
+	scheduler.cron = next
+	next.Start()
+
+	for _, entry := range entries {
+		log.Infof("Scheduled %s at %q", entry.Name, entry.Cron)
+	}
+	return nil
+}
+
+// Stop stops scheduling runs and waits for the runs in flight to finish
+func (scheduler *Scheduler) Stop() {
+	scheduler.mutex.Lock()
+	scheduler.stopped = true
+	if scheduler.cron != nil {
+		scheduler.cron.Stop()
+	}
+	scheduler.mutex.Unlock()
+
+	scheduler.wait.Wait()
+}
+
+// Running returns the names of the entries running now
+func (scheduler *Scheduler) Running() (names []string) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	for name := range scheduler.running {
+		names = append(names, name)
+	}
+	return
+}
+
+// trigger runs an entry, unless it is already running or the scheduler stopped
+func (scheduler *Scheduler) trigger(entry Entry) {
+	scheduler.mutex.Lock()
+	if scheduler.stopped {
+		scheduler.mutex.Unlock()
+		return
+	}
+	if scheduler.running[entry.Name] {
+		scheduler.mutex.Unlock()
+		log.Warnf("Skipping %s, the previous run has not finished", entry.Name)
+		return
+	}
+	scheduler.running[entry.Name] = true
+	scheduler.wait.Add(1)
+	scheduler.mutex.Unlock()
+
+	defer func() {
+		scheduler.mutex.Lock()
+		delete(scheduler.running, entry.Name)
+		scheduler.mutex.Unlock()
+		scheduler.wait.Done()
+	}()
+
+	log.Infof("Running scheduled healthchecks %s", entry.Name)
+	if err := scheduler.run(entry); err != nil {
+		log.Errorf("Scheduled healthchecks %s: %v", entry.Name, err)
+		return
+	}
+	log.Infof("Scheduled healthchecks %s passed", entry.Name)
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cfpb/rhobot/internal/database"
+	"github.com/cfpb/rhobot/internal/healthcheck"
+	"github.com/cfpb/rhobot/internal/jsonschema"
+	"github.com/cfpb/rhobot/internal/report"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultTTL is how long a suite's result is served from the cache
+// when its ttl is not set
+const DefaultTTL = 30 * time.Second
+
+// DefaultTimeout limits how long a suite runs when its timeout is not set
+const DefaultTimeout = time.Minute
+
+// Suite is a healthcheck suite served at /healthz/{name}. Paths are relative
+// to the serve file and ${NAME} in the dburi is read from the environment.
+type Suite struct {
+	Name         string            `yaml:"name" jsonschema:"required"`
+	Healthchecks string            `yaml:"healthchecks" jsonschema:"required"`
+	DBURI        string            `yaml:"dburi,omitempty"`
+	FailOn       string            `yaml:"fail_on,omitempty"`
+	TTL          time.Duration     `yaml:"ttl,omitempty"`
+	Timeout      time.Duration     `yaml:"timeout,omitempty"`
+	Vars         map[string]string `yaml:"vars,omitempty"`
+	Tags         []string          `yaml:"tags,omitempty"`
+	SkipTags     []string          `yaml:"skip_tags,omitempty"`
+}
+
+// Format is for unmarshaling a serve file
+type Format struct {
+	Suites []Suite `yaml:"suites" jsonschema:"required"`
+}
+
+// FormatSchema returns the JSON Schema of a serve file
+func FormatSchema() jsonschema.Schema {
+	return jsonschema.Generate(Format{}, "rhobot serve file")
+}
+
+// ReadServeFromFile loads and checks a serve file
+func ReadServeFromFile(path string) (format Format, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(data, &format); err != nil {
+		return format, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(format.Suites) == 0 {
+		return format, fmt.Errorf("%s: no suites to serve", path)
+	}
+
+	names := make(map[string]bool)
+	for i := range format.Suites {
+		suite := &format.Suites[i]
+		if suite.Name == "" || strings.Contains(suite.Name, "/") {
+			return format, fmt.Errorf("%s: every suite needs a name without a /", path)
+		}
+		if suite.Healthchecks == "" {
+			return format, fmt.Errorf("%s: suite %q needs healthchecks to run", path, suite.Name)
+		}
+		if names[suite.Name] {
+			return format, fmt.Errorf("%s: suite %q is defined more than once", path, suite.Name)
+		}
+		names[suite.Name] = true
+
+		if !filepath.IsAbs(suite.Healthchecks) {
+			suite.Healthchecks = filepath.Join(filepath.Dir(path), suite.Healthchecks)
+		}
+		suite.DBURI = os.ExpandEnv(suite.DBURI)
+	}
+	return
+}
+
+// Server answers /healthz/{suite} with the result of running the suite,
+// 200 when it passes and 503 when a healthcheck at or above the suite's
+// fail_on severity failed
+type Server struct {
+	suites map[string]*served
+}
+
+// served is a suite ready to run, and its last result
+type served struct {
+	suite       Suite
+	healthCheck healthcheck.Format
+	threshold   int
+	cxn         *sql.DB
+
+	// mutex is held while the suite runs, so requests arriving meanwhile
+	// wait for that run instead of starting another
+	mutex  sync.Mutex
+	status int
+	body   []byte
+	ran    time.Time
+}
+
+// New reads every suite's healthchecks and opens its database, using dbURI
+// for suites without their own
+func New(suites []Suite, dbURI string) (_ *Server, err error) {
+	server := &Server{suites: make(map[string]*served)}
+	defer func() {
+		if err != nil {
+			server.Close()
+		}
+	}()
+
+	for _, suite := range suites {
+		healthChecks, err := healthcheck.ReadHealthChecksFromPath(suite.Healthchecks, suite.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("suite %q: %v", suite.Name, err)
+		}
+		if len(suite.Tags) > 0 || len(suite.SkipTags) > 0 {
+			healthChecks.FilterTags(suite.Tags, suite.SkipTags)
+		}
+
+		failOn := suite.FailOn
+		if failOn == "" {
+			failOn = "error"
+		}
+		threshold, err := healthcheck.ParseFailOn(failOn)
+		if err != nil {
+			return nil, fmt.Errorf("suite %q: %v", suite.Name, err)
+		}
+
+		uri := suite.DBURI
+		if uri == "" {
+			uri = dbURI
+		}
+		cxn, err := database.Open(uri)
+		if err != nil {
+			return nil, fmt.Errorf("suite %q: %v", suite.Name, err)
+		}
+
+		if suite.TTL <= 0 {
+			suite.TTL = DefaultTTL
+		}
+		if suite.Timeout <= 0 {
+			suite.Timeout = DefaultTimeout
+		}
+		server.suites[suite.Name] = &served{suite: suite, healthCheck: healthChecks, threshold: threshold, cxn: cxn}
+	}
+	return server, nil
+}
+
+// Close closes the suites' database connections
+func (server *Server) Close() {
+	for _, s := range server.suites {
+		s.cxn.Close()
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/healthz/")
+	s, ok := server.suites[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, body, ran := s.result()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(ran).Seconds())))
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(s.suite.TTL.Seconds())))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// result returns the cached result of the suite, running it when the cache expired
+func (s *served) result() (int, []byte, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.body != nil && time.Since(s.ran) < s.suite.TTL {
+		return s.status, s.body, s.ran
+	}
+
+	s.ran = time.Now()
+	status, body, err := s.run()
+	if err != nil {
+		log.Errorf("suite %s: %v", s.suite.Name, err)
+		body, _ = json.Marshal(map[string]string{"name": s.suite.Name, "error": err.Error()})
+		status = http.StatusServiceUnavailable
+	}
+	s.status, s.body = status, body
+	return s.status, s.body, s.ran
+}
+
+// run runs the suite and renders its results with report.JSONReportRunner.
+// The run is shared by every request waiting on it, so it is bounded by the
+// suite's timeout rather than by any one request.
+func (s *served) run() (int, []byte, error) {
+	log.Infof("Running healthchecks for /healthz/%s", s.suite.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), s.suite.Timeout)
+	defer cancel()
+
+	if err := s.cxn.PingContext(ctx); err != nil {
+		return 0, nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	started := time.Now()
+	results, hcerrs := s.healthCheck.PreformHealthChecksContext(ctx, s.cxn)
+	numErrors, numWarnings, fatal := healthcheck.EvaluateHCErrors(hcerrs)
+
+	var elements []report.Element
+	for _, result := range results {
+		elements = append(elements, result)
+	}
+	code := healthcheck.ExitCode(numErrors, numWarnings, fatal, s.threshold)
+	rs := report.Set{Elements: elements, Metadata: map[string]interface{}{
+		"name":      s.suite.Name,
+		"status":    healthcheck.StatusHealthchecks(numErrors, numWarnings, fatal),
+		"healthy":   code == healthcheck.ExitPass,
+		"timestamp": started.Format(time.RFC3339),
+		"duration":  time.Since(started).String(),
+	}}
+
+	reader, err := report.JSONReportRunner{}.ReportReader(rs)
+	if err != nil {
+		return 0, nil, err
+	}
+	var body bytes.Buffer
+	if _, err = body.ReadFrom(reader); err != nil {
+		return 0, nil, err
+	}
+
+	if code != healthcheck.ExitPass {
+		return http.StatusServiceUnavailable, body.Bytes(), nil
+	}
+	return http.StatusOK, body.Bytes(), nil
+}
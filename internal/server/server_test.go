@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cfpb/rhobot/internal/database"
+)
+
+// newTestServer serves testdata/serve.yml against a SQLite database of orders
+func newTestServer(t *testing.T) (*Server, func(string)) {
+	dir := t.TempDir()
+	os.Setenv("RHOBOT_TEST_DIR", dir)
+	defer os.Unsetenv("RHOBOT_TEST_DIR")
+
+	cxn, err := database.Open("sqlite://" + dir + "/orders.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cxn.Close() })
+	exec := func(query string) {
+		if _, err := cxn.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec("CREATE TABLE orders (id integer, status text); INSERT INTO orders VALUES (1, 'shipped');")
+
+	format, err := ReadServeFromFile("testdata/serve.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := New(format.Suites, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server, exec
+}
+
+// get requests a path and decodes the JSON body
+func get(t *testing.T, server *Server, path string) (int, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var body map[string]interface{}
+	if recorder.Code != http.StatusNotFound {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s did not return json: %v\n%s", path, err, recorder.Body)
+		}
+	}
+	return recorder.Code, body
+}
+
+func TestServeHealthz(t *testing.T) {
+	server, exec := newTestServer(t)
+
+	status, body := get(t, server, "/healthz/orders")
+	if status != http.StatusOK {
+		t.Errorf("passing suite should be 200, got %d %v", status, body)
+	}
+	if elements, _ := body["elements"].([]interface{}); len(elements) != 2 {
+		t.Errorf("body should hold every healthcheck: %v", body)
+	}
+
+	// a pending order is a warning, failing only the strict suite
+	exec("INSERT INTO orders VALUES (2, 'pending')")
+	if status, body = get(t, server, "/healthz/orders-strict"); status != http.StatusServiceUnavailable {
+		t.Errorf("warning should fail a suite failing on warn, got %d %v", status, body)
+	}
+	metadata, _ := body["metadata"].(map[string]interface{})
+	if metadata["status"] != "WARNING(s) 1" || metadata["healthy"] != false {
+		t.Errorf("metadata should describe the failure: %v", metadata)
+	}
+
+	// an error is cached for the ttl of the suite
+	exec("DELETE FROM orders")
+	if status, _ = get(t, server, "/healthz/orders"); status != http.StatusOK {
+		t.Errorf("result should come from the cache, got %d", status)
+	}
+	status, body = get(t, server, "/healthz/orders-strict")
+	if metadata, _ = body["metadata"].(map[string]interface{}); metadata["status"] != "ERROR(s) 1" {
+		t.Errorf("expired result should run again, got %d %v", status, metadata)
+	}
+
+	if status, body = get(t, server, "/healthz/offline"); status != http.StatusServiceUnavailable || body["error"] == nil {
+		t.Errorf("unreachable database should be 503 with an error, got %d %v", status, body)
+	}
+	if status, _ = get(t, server, "/healthz/unknown"); status != http.StatusNotFound {
+		t.Errorf("unknown suite should be 404, got %d", status)
+	}
+	if status, _ = get(t, server, "/orders"); status != http.StatusNotFound {
+		t.Errorf("path outside /healthz/ should be 404, got %d", status)
+	}
+}
+
+func TestSuiteTimeout(t *testing.T) {
+	dir := t.TempDir()
+	suites := []Suite{{Name: "slow", Healthchecks: "testdata/slow.yml", DBURI: "sqlite://" + dir + "/slow.db", Timeout: 100 * time.Millisecond}}
+	server, err := New(suites, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	started := time.Now()
+	if status, body := get(t, server, "/healthz/slow"); status != http.StatusServiceUnavailable {
+		t.Errorf("suite running past its timeout should be 503, got %d %v", status, body)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("suite should have been stopped at its timeout, ran for %v", elapsed)
+	}
+}
+
+func TestNewClosesSuitesOnError(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv("RHOBOT_TEST_DIR", dir)
+	defer os.Unsetenv("RHOBOT_TEST_DIR")
+
+	format, err := ReadServeFromFile("testdata/serve.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := append(format.Suites, Suite{Name: "broken", Healthchecks: format.Suites[0].Healthchecks, FailOn: "info"})
+	server, err := New(suites, "")
+	if err == nil || server != nil {
+		t.Errorf("a suite with an unknown fail_on should not be served: %v %v", server, err)
+	}
+}